@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+)
+
+func init() {
+	rootCmd.AddCommand(costCmd)
+	costCmd.Flags().BoolP("offline", "", false, "Estimate the rendered cluster only, without the changes pending against the state store")
+	costCmd.Flags().StringP("output", "o", "text", "Output format, text or json")
+}
+
+var costCmd = &cobra.Command{
+	Use:   "cost",
+	Short: "Estimate the monthly cost of a cluster's instance groups",
+	Long: "Estimate the monthly cost of a cluster's instance groups from the price table\n" +
+		"in the workspace, and the cost change of the changes pending against the state store.",
+	Args: singleClusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := singleClusterFile(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		offline, _ := cmd.Flags().GetBool("offline")
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			fmt.Fprintf(os.Stderr, "unknown output format %v\n", output)
+			os.Exit(1)
+		}
+		if err := kops.ClusterCost(cmdContext, file, offline, os.Stdout, output == "json"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
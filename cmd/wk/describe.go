@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+)
+
+func init() {
+	rootCmd.AddCommand(describeCmd)
+	describeCmd.Flags().StringP("output", "o", "text", "Output format, text or json")
+}
+
+var describeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "Summarize a cluster",
+	Long: "Render a cluster file and summarize its Kubernetes version, networking, subnets,\n" +
+		"instance groups, capacity from the machine type table in the workspace, and channels.",
+	Args: singleClusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := singleClusterFile(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			fmt.Fprintf(os.Stderr, "unknown output format %v\n", output)
+			os.Exit(1)
+		}
+		if err := kops.ClusterDescribe(cmdContext, file, os.Stdout, output == "json"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+	"github.com/wish/wk/pkg/util"
+)
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+	driftCmd.Flags().StringP("output", "o", "text", "Output format, text or json")
+}
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Report clusters whose state store or channels differ from the workspace",
+	Long: "Report clusters whose state store or channels differ from the workspace.\n" +
+		"Without arguments all clusters in the workspace inventory are checked.\n" +
+		"Exits with 3 if drift was found and 1 on errors.",
+	Run: func(cmd *cobra.Command, args []string) {
+		var files []string
+		var err error
+		if len(args) == 0 && !cmd.Flags().Changed("selector") {
+			files, err = inventoryFiles()
+		} else {
+			files, err = clusterFiles(cmd, args)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			fmt.Fprintf(os.Stderr, "unknown output format %v\n", output)
+			os.Exit(1)
+		}
+		if err := kops.Drift(cmdContext, files, os.Stdout, output == "json"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+// inventoryFiles returns all cluster files in the workspace inventory
+func inventoryFiles() ([]string, error) {
+	conf, err := util.GetConfig("")
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, e := range conf.Clusters {
+		files = append(files, conf.Path(e))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no clusters in workspace inventory")
+	}
+	return files, nil
+}
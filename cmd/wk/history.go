@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history <cluster file> [record id]",
+	Short: "List cluster's apply history, or show a single record",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("selector") {
+			return cobra.MaximumNArgs(1)(cmd, args)
+		}
+		return cobra.RangeArgs(1, 2)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// The optional record id follows the cluster file, if one is given
+		files, ids := args, []string{}
+		if cmd.Flags().Changed("selector") {
+			files, ids = nil, args
+		} else if len(args) > 1 {
+			files, ids = args[:1], args[1:]
+		}
+		file, err := singleClusterFile(cmd, files)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		id := ""
+		if len(ids) > 0 {
+			id = ids[0]
+		}
+		if err := kops.History(cmdContext, file, id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+	"github.com/wish/wk/pkg/util"
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringP("selector", "l", "", "Select clusters from the workspace inventory by label, e.g. env=prod,region=us-west")
+	rootCmd.AddCommand(lsCmd)
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List clusters in the workspace inventory",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf, err := util.GetConfig("")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sel, err := selector(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tLABELS")
+		for _, e := range conf.Select(sel) {
+			fmt.Fprintf(w, "%v\t%v\n", e.File, formatLabels(e.Labels))
+		}
+		w.Flush()
+	},
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := []string{}
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := []string{}
+	for _, k := range keys {
+		out = append(out, k+"="+labels[k])
+	}
+	return strings.Join(out, ",")
+}
+
+func selector(cmd *cobra.Command) (util.Selector, error) {
+	s, err := cmd.Flags().GetString("selector")
+	if err != nil {
+		return nil, err
+	}
+	return util.ParseSelector(s)
+}
+
+// clusterArgs accepts cluster files as arguments, or none when a selector is given
+func clusterArgs(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed("selector") {
+		if len(args) > 0 {
+			return fmt.Errorf("cluster files and --selector cannot be combined")
+		}
+		return nil
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
+
+// singleClusterArgs accepts one cluster file as argument, or none when a selector is given
+func singleClusterArgs(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed("selector") {
+		return clusterArgs(cmd, args)
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
+// clusterFiles returns the cluster files given as arguments or selected from
+// the workspace inventory
+func clusterFiles(cmd *cobra.Command, args []string) ([]string, error) {
+	if !cmd.Flags().Changed("selector") {
+		return kops.ExpandFiles(args)
+	}
+	conf, err := util.GetConfig("")
+	if err != nil {
+		return nil, err
+	}
+	sel, err := selector(cmd)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, e := range conf.Select(sel) {
+		files = append(files, conf.Path(e))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no clusters match selector %v", sel)
+	}
+	return files, nil
+}
+
+// singleClusterFile returns the only cluster file given or selected
+func singleClusterFile(cmd *cobra.Command, args []string) (string, error) {
+	files, err := clusterFiles(cmd, args)
+	if err != nil {
+		return "", err
+	}
+	if len(files) != 1 {
+		return "", fmt.Errorf("expected exactly one cluster, got %v", len(files))
+	}
+	return files[0], nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+)
+
+func init() {
+	rootCmd.AddCommand(kubeconfigCmd)
+	kubeconfigCmd.Flags().String("kubeconfig", "", "Kubeconfig to merge into, by default $KUBECONFIG or ~/.kube/config")
+	kubeconfigCmd.Flags().Bool("prune", false, "Remove contexts of clusters no longer in the workspace inventory")
+}
+
+var kubeconfigCmd = &cobra.Command{
+	Use:   "kubeconfig",
+	Short: "Export clusters' kubeconfigs and merge them into a kubeconfig",
+	Long: "Export clusters' kubeconfigs and merge them into a kubeconfig.\n" +
+		"Without arguments all clusters in the workspace inventory are exported.",
+	Run: func(cmd *cobra.Command, args []string) {
+		var files []string
+		var err error
+		if len(args) == 0 && !cmd.Flags().Changed("selector") {
+			files, err = inventoryFiles()
+		} else {
+			files, err = clusterFiles(cmd, args)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		opts := kops.KubeconfigOptions{}
+		opts.Path, _ = cmd.Flags().GetString("kubeconfig")
+		opts.Prune, _ = cmd.Flags().GetBool("prune")
+		if err := kops.Kubeconfig(cmdContext, files, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
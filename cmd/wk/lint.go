@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+)
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check cluster's kops specs offline, without touching the state store or the cluster",
+	Args:  clusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := clusterFiles(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		code := 0
+		for _, file := range files {
+			if err := kops.ValidateSpec(cmdContext, file); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				if c := exitCode(err); c > code {
+					code = c
+				}
+			}
+		}
+		os.Exit(code)
+	},
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+)
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	lockCmd.AddCommand(lockStatusCmd)
+	lockCmd.AddCommand(lockBreakCmd)
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Inspect and break cluster apply locks",
+}
+
+var lockStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show who holds cluster's lock",
+	Args:  clusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := clusterFiles(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, file := range files {
+			if err := kops.LockStatus(cmdContext, file); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var lockBreakCmd = &cobra.Command{
+	Use:   "break",
+	Short: "Remove cluster's lock regardless of its owner",
+	Args:  singleClusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := singleClusterFile(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := kops.LockBreak(cmdContext, file); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
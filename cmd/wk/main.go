@@ -6,12 +6,16 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/wish/wk/pkg/kops"
 	"github.com/wish/wk/pkg/opa"
+	"github.com/wish/wk/pkg/util"
 )
 
 func init() {
@@ -21,8 +25,34 @@ func init() {
 	clusterApplyCmd.Flags().BoolP("force-update", "f", false, "Force update")
 	clusterApplyCmd.Flags().BoolP("preview", "p", false, "Preview changes")
 	clusterApplyCmd.Flags().BoolP("no-update", "n", false, "Create resources but don't do kops update cluster")
+	clusterApplyCmd.Flags().StringP("diff-out", "", "", "Write structural diffs as JSON to file.")
+	clusterApplyCmd.Flags().StringP("report", "", "", "Write a report of the run as JSON to file.")
+	clusterApplyCmd.Flags().IntP("parallel", "", 4, "Number of clusters applied concurrently")
 
+	clusterApplyCmd.Flags().BoolP("create", "", false, "Create the cluster if it does not exist")
+	clusterApplyCmd.Flags().BoolP("auto-approve", "", false, "Apply changes without asking for confirmation")
+	clusterApplyCmd.Flags().BoolP("rolling-update", "", false, "Roll changed instance groups after update")
+	clusterApplyCmd.Flags().BoolP("validate", "", false, "Wait for the cluster to validate after update")
+	clusterApplyCmd.Flags().DurationP("validate-timeout", "", 10*time.Minute, "How long to wait for the cluster to validate")
 	opa.AddOPAOpts(clusterApplyCmd)
+	kops.AddRollingUpdateOpts(clusterApplyCmd)
+	kops.AddTimeoutOpts(clusterApplyCmd)
+
+	clusterApplyCmd.AddCommand(clusterRollingUpdateCmd)
+	kops.AddRollingUpdateOpts(clusterRollingUpdateCmd)
+	clusterApplyCmd.AddCommand(clusterValidateCmd)
+	clusterApplyCmd.AddCommand(clusterDeleteCmd)
+	clusterDeleteCmd.Flags().StringP("confirm", "", "", "Cluster name to confirm deletion non-interactively")
+	clusterApplyCmd.AddCommand(clusterPlanCmd)
+	clusterPlanCmd.Flags().StringP("out", "o", "plan.json", "Plan file to write")
+	opa.AddOPAOpts(clusterPlanCmd)
+	clusterApplyCmd.AddCommand(clusterApplyPlanCmd)
+	addUpdateFlags(clusterApplyPlanCmd)
+	clusterApplyCmd.AddCommand(clusterRollbackCmd)
+	clusterRollbackCmd.Flags().BoolP("preview", "p", false, "Preview changes")
+	clusterRollbackCmd.Flags().BoolP("auto-approve", "", false, "Apply changes without asking for confirmation")
+	addUpdateFlags(clusterRollbackCmd)
+	clusterValidateCmd.Flags().DurationP("validate-timeout", "", 10*time.Minute, "How long to wait for the cluster to validate")
 
 	rootCmd.AddCommand(clusterEditCmd)
 	rootCmd.AddCommand(clusterEditIGCmd)
@@ -53,32 +83,241 @@ var rootCmd = &cobra.Command{
 
 var clusterApplyCmd = &cobra.Command{
 	Use:   "cluster",
-	Short: "Apply changes to cluster",
-	Args:  cobra.ExactArgs(1),
+	Short: "Apply changes to clusters",
+	Args:  clusterArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		files, err := clusterFiles(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(files) > 1 {
+			if cmd.Flags().Changed("dry") || cmd.Flags().Changed("diff-out") {
+				fmt.Fprintln(os.Stderr, "--dry and --diff-out can only be used with a single cluster")
+				os.Exit(1)
+			}
+			autoApprove, _ := cmd.Flags().GetBool("auto-approve")
+			preview, _ := cmd.Flags().GetBool("preview")
+			if !autoApprove && !preview {
+				fmt.Fprintln(os.Stderr, "applying several clusters requires --auto-approve or --preview")
+				os.Exit(1)
+			}
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			report, _ := cmd.Flags().GetString("report")
+			if err := kops.ClusterApplyMany(cmdContext, files, passthroughFlags(cmd.Flags(), "parallel", "selector", "report"), parallel, report); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+
 		dry, _ := cmd.Flags().GetString("dry")
 		forceUpdate, _ := cmd.Flags().GetBool("force-update")
 		preview, _ := cmd.Flags().GetBool("preview")
 		noUpdate, _ := cmd.Flags().GetBool("no-update")
+		diffOut, _ := cmd.Flags().GetString("diff-out")
+		report, _ := cmd.Flags().GetString("report")
+		create, _ := cmd.Flags().GetBool("create")
+		rolling, _ := cmd.Flags().GetBool("rolling-update")
+		validate, _ := cmd.Flags().GetBool("validate")
+		validateTimeout, _ := cmd.Flags().GetDuration("validate-timeout")
 		opaQuery, err := opa.FromFlags(cmd.Flags())
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+		timeouts, err := kops.TimeoutsFromFlags(cmd.Flags())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		autoApprove, err := checkApproval(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 
-		if err := kops.ClusterApply(context.Background(), args[0], dry, forceUpdate, noUpdate, preview, opaQuery); err != nil {
+		opts := kops.ClusterApplyOptions{
+			DryFile:     dry,
+			ForceUpdate: forceUpdate,
+			NoUpdate:    noUpdate,
+			Preview:     preview,
+			DiffFile:    diffOut,
+			ReportFile:  report,
+			Create:      create,
+			AutoApprove: autoApprove,
+			In:          os.Stdin,
+
+			Validate:        validate,
+			ValidateTimeout: validateTimeout,
+			Timeouts:        timeouts,
+		}
+		if rolling {
+			rollingOpts, err := kops.RollingUpdateFromFlags(cmd.Flags())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			opts.RollingUpdate = &rollingOpts
+		}
+		if err := kops.ClusterApply(cmdContext, files[0], opts, opaQuery); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+var clusterRollingUpdateCmd = &cobra.Command{
+	Use:   "rolling-update",
+	Short: "Roll cluster's instance groups, masters first",
+	Args:  clusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := clusterFiles(cmd, args)
+		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+		opts, err := kops.RollingUpdateFromFlags(cmd.Flags())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, file := range files {
+			if err := kops.ClusterRollingUpdate(cmdContext, file, opts); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var clusterValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Wait for cluster to validate",
+	Args:  clusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := clusterFiles(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		timeout, _ := cmd.Flags().GetDuration("validate-timeout")
+		for _, file := range files {
+			if err := kops.ClusterValidate(cmdContext, file, timeout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitCode(err))
+			}
+		}
 	},
 }
 
+var clusterDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete cluster after confirmation",
+	Args:  singleClusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := singleClusterFile(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		confirm, _ := cmd.Flags().GetString("confirm")
+		if err := kops.ClusterDelete(cmdContext, file, confirm, os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var clusterPlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Save cluster changes to a plan file",
+	Args:  singleClusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := singleClusterFile(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		out, _ := cmd.Flags().GetString("out")
+		opaQuery, err := opa.FromFlags(cmd.Flags())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := kops.ClusterPlan(cmdContext, file, out, opaQuery); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var clusterApplyPlanCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply a plan file saved by plan",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := kops.ClusterApplyPlan(cmdContext, args[0], updateOptsFromFlags(cmd)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+var clusterRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Re-apply cluster specs from a revision listed by history",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := updateOptsFromFlags(cmd)
+		opts.Preview, _ = cmd.Flags().GetBool("preview")
+		autoApprove, err := checkApproval(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.AutoApprove, opts.In = autoApprove, os.Stdin
+		if err := kops.ClusterRollback(cmdContext, args[0], args[1], opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+// addUpdateFlags adds the flags controlling kops update to commands applying
+// specs other than the rendered cluster file
+func addUpdateFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("force-update", "f", false, "Force update")
+	cmd.Flags().BoolP("no-update", "n", false, "Create resources but don't do kops update cluster")
+	cmd.Flags().BoolP("validate", "", false, "Wait for the cluster to validate after update")
+	cmd.Flags().DurationP("validate-timeout", "", 10*time.Minute, "How long to wait for the cluster to validate")
+	cmd.Flags().StringP("report", "", "", "Write a report of the run as JSON to file.")
+	kops.AddTimeoutOpts(cmd)
+}
+
+func updateOptsFromFlags(cmd *cobra.Command) kops.ClusterApplyOptions {
+	forceUpdate, _ := cmd.Flags().GetBool("force-update")
+	noUpdate, _ := cmd.Flags().GetBool("no-update")
+	validate, _ := cmd.Flags().GetBool("validate")
+	validateTimeout, _ := cmd.Flags().GetDuration("validate-timeout")
+	report, _ := cmd.Flags().GetString("report")
+	timeouts, _ := kops.TimeoutsFromFlags(cmd.Flags())
+	return kops.ClusterApplyOptions{
+		ForceUpdate:     forceUpdate,
+		NoUpdate:        noUpdate,
+		Validate:        validate,
+		ValidateTimeout: validateTimeout,
+		ReportFile:      report,
+		Timeouts:        timeouts,
+	}
+}
+
 var clusterEditCmd = &cobra.Command{
 	Use:    "cluster-edit",
 	Hidden: true,
 	Args:   cobra.ExactArgs(5),
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := kops.ClusterEdit(context.Background(), args); err != nil {
+		if err := kops.ClusterEdit(cmdContext, args); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
@@ -90,7 +329,7 @@ var clusterEditIGCmd = &cobra.Command{
 	Hidden: true,
 	Args:   cobra.ExactArgs(6),
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := kops.ClusterEditIG(context.Background(), args); err != nil {
+		if err := kops.ClusterEditIG(cmdContext, args); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
@@ -100,8 +339,13 @@ var clusterEditIGCmd = &cobra.Command{
 var channelsApplyCmd = &cobra.Command{
 	Use:   "channels",
 	Short: "Apply changes to cluster's channels",
-	Args:  cobra.ExactArgs(1),
+	Args:  singleClusterArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		file, err := singleClusterFile(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		dry, _ := cmd.Flags().GetString("dry")
 		opaQuery, err := opa.FromFlags(cmd.Flags())
 		if err != nil {
@@ -109,14 +353,69 @@ var channelsApplyCmd = &cobra.Command{
 			os.Exit(1)
 		}
 		dry = filepath.Clean(dry)
-		if err := kops.ChannelsApply(context.Background(), args[0], dry, opaQuery); err != nil {
+		if err := kops.ChannelsApply(cmdContext, file, dry, opaQuery); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 	},
 }
 
+// checkApproval returns whether --auto-approve is set. Without it, changes
+// are confirmed interactively, so stdin must be a terminal unless previewing.
+func checkApproval(cmd *cobra.Command) (bool, error) {
+	autoApprove, _ := cmd.Flags().GetBool("auto-approve")
+	preview, _ := cmd.Flags().GetBool("preview")
+	dry, _ := cmd.Flags().GetString("dry")
+	if !autoApprove && !preview && dry == "" && !util.IsTerminal(os.Stdin) {
+		return false, fmt.Errorf("stdin is not a terminal to confirm changes, use --auto-approve to apply without confirmation")
+	}
+	return autoApprove, nil
+}
+
+// passthroughFlags returns the flags set on the command line, except skip, as arguments
+func passthroughFlags(flags *pflag.FlagSet, skip ...string) []string {
+	args := []string{}
+	flags.Visit(func(f *pflag.Flag) {
+		for _, s := range skip {
+			if f.Name == s {
+				return
+			}
+		}
+		switch f.Value.Type() {
+		case "stringArray":
+			vals, _ := flags.GetStringArray(f.Name)
+			for _, v := range vals {
+				args = append(args, "--"+f.Name+"="+v)
+			}
+		case "stringSlice":
+			vals, _ := flags.GetStringSlice(f.Name)
+			args = append(args, "--"+f.Name+"="+strings.Join(vals, ","))
+		default:
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+// exitCode returns the process exit code for err
+func exitCode(err error) int {
+	switch err.(type) {
+	case *kops.ValidationError, *kops.SpecError:
+		return kops.ValidationExitCode
+	case *kops.DriftError:
+		return kops.DriftExitCode
+	}
+	return 1
+}
+
+// cmdContext is cancelled when wk is interrupted, see util.SignalContext
+var cmdContext = context.Background()
+
 func main() {
+	kops.BuildSha = BuildSha
+	var stop func()
+	cmdContext, stop = util.SignalContext(context.Background())
+	defer stop()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
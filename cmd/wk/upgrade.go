@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/wk/pkg/kops"
+)
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+	upgradeCmd.Flags().StringP("to", "", "", "Kubernetes version to upgrade to for this run, instead of the one set in the cluster file")
+	upgradeCmd.Flags().BoolP("preview", "p", false, "Only run the checks and show the plan")
+	upgradeCmd.Flags().BoolP("auto-approve", "", false, "Run all steps without asking for confirmation")
+	upgradeCmd.Flags().DurationP("validate-timeout", "", 10*time.Minute, "How long to wait for the cluster to validate")
+	kops.AddRollingUpdateOpts(upgradeCmd)
+	kops.AddTimeoutOpts(upgradeCmd)
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade the Kubernetes version of a cluster step by step",
+	Long: "Check the upgrade of a cluster to the Kubernetes version set in its cluster file, or to\n" +
+		"the version given with --to, against the version skew rules, the image compatibility\n" +
+		"table in the workspace and the APIs used by its channels, show the plan, then apply,\n" +
+		"update, roll and validate the cluster, asking before each step. A version given with\n" +
+		"--to only overrides the cluster file for this run; set it in the cluster file as well.",
+	Args: singleClusterArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := singleClusterFile(cmd, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		to, _ := cmd.Flags().GetString("to")
+		preview, _ := cmd.Flags().GetBool("preview")
+		validateTimeout, _ := cmd.Flags().GetDuration("validate-timeout")
+		rollingOpts, err := kops.RollingUpdateFromFlags(cmd.Flags())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		timeouts, err := kops.TimeoutsFromFlags(cmd.Flags())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		autoApprove, err := checkApproval(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		opts := kops.UpgradeOptions{
+			To:              to,
+			Preview:         preview,
+			AutoApprove:     autoApprove,
+			In:              os.Stdin,
+			RollingUpdate:   rollingOpts,
+			ValidateTimeout: validateTimeout,
+			Timeouts:        timeouts,
+		}
+		if err := kops.ClusterUpgrade(cmdContext, file, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
@@ -4,7 +4,8 @@ go 1.13
 
 require (
 	cloud.google.com/go v0.37.4 // indirect
-	github.com/aws/aws-sdk-go v1.19.16 // indirect
+	github.com/aws/aws-sdk-go v1.19.16
+	github.com/blang/semver v3.5.1+incompatible
 	github.com/denverdino/aliyungo v0.0.0-20190410085603-611ead8a6fed // indirect
 	github.com/go-ini/ini v1.42.0 // indirect
 	github.com/golang/protobuf v1.3.1 // indirect
@@ -19,7 +20,7 @@ require (
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.3
 	go.opencensus.io v0.20.2 // indirect
-	golang.org/x/crypto v0.0.0-20190422183909-d864b10871cd // indirect
+	golang.org/x/crypto v0.0.0-20190422183909-d864b10871cd
 	golang.org/x/oauth2 v0.0.0-20190402181905-9f3314589c9a // indirect
 	golang.org/x/text v0.3.1 // indirect
 	google.golang.org/api v0.3.2 // indirect
@@ -27,7 +28,7 @@ require (
 	google.golang.org/genproto v0.0.0-20190418145605-e7d98fc518a7 // indirect
 	google.golang.org/grpc v1.20.1 // indirect
 	gopkg.in/ini.v1 v1.46.0 // indirect
-	k8s.io/apimachinery v0.0.0-20190424132444-f1e86e15343c // indirect
+	k8s.io/apimachinery v0.0.0-20190424132444-f1e86e15343c
 	k8s.io/client-go v11.0.0+incompatible // indirect
 	k8s.io/kops v1.11.1-0.20190301151100-0f2aa8d30d89
 	sigs.k8s.io/yaml v1.1.0
@@ -0,0 +1,268 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/kops/util/pkg/vfs"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// BuildSha is the git commit wk was built from, recorded in audit records
+var BuildSha = ""
+
+// AuditRecord describes a single change made to a cluster by wk
+type AuditRecord struct {
+	ID      string
+	Kind    string
+	Cluster string
+	Time    time.Time
+
+	User     string
+	Host     string
+	BuildSha string
+	// GitCommit is the workspace commit, GitDirty whether it had uncommitted changes
+	GitCommit string
+	GitDirty  bool
+
+	// SpecHash is the sha256 of the rendered cluster file
+	SpecHash           string
+	ClusterDiff        string            `json:",omitempty"`
+	InstanceGroupDiffs map[string]string `json:",omitempty"`
+	Updated            bool
+	Error              string `json:",omitempty"`
+
+	// Channels maps published channel files to their hashes
+	Channels map[string]string `json:",omitempty"`
+}
+
+func historyPath(cluster *types.Cluster) (vfs.Path, error) {
+	p, err := stateStorePath(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return p.Join("history"), nil
+}
+
+// newAuditRecord fills in the fields common to all records
+func newAuditRecord(kind, file, tfile string, cluster *types.Cluster) (*AuditRecord, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	r := &AuditRecord{
+		ID:       fmt.Sprintf("%v-%x", now.Format("20060102T150405Z"), suffix),
+		Kind:     kind,
+		Cluster:  cluster.Name,
+		Time:     now,
+		User:     currentUser(),
+		Host:     currentHost(),
+		BuildSha: BuildSha,
+	}
+	if b, err := ioutil.ReadFile(tfile); err == nil {
+		r.SpecHash = fmt.Sprintf("%x", sha256.Sum256(b))
+	}
+	if ctxDir, err := util.GetContextDir(file); err == nil {
+		r.GitCommit, r.GitDirty = gitCommit(ctxDir)
+	}
+	return r, nil
+}
+
+// gitCommit returns the HEAD commit of the repository containing dir and
+// whether it has uncommitted changes. The commit is empty outside git.
+func gitCommit(dir string) (string, bool) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	return strings.TrimSpace(string(out)), err == nil && len(bytes.TrimSpace(status)) > 0
+}
+
+// clusterAuditRecord records an apply of the rendered cluster with the resulting state
+func clusterAuditRecord(file, tfile string, cluster *types.Cluster, s *State, updated bool, applyErr error) (*AuditRecord, error) {
+	r, err := newAuditRecord("cluster", file, tfile, cluster)
+	if err != nil {
+		return nil, err
+	}
+	r.InstanceGroupDiffs = map[string]string{}
+	if s != nil {
+		r.ClusterDiff = s.Cluster.DiffText
+		for name, ig := range s.InstanceGroups {
+			if ig.DiffText != "" {
+				r.InstanceGroupDiffs[name] = ig.DiffText
+			}
+		}
+	}
+	r.Updated = updated
+	if applyErr != nil {
+		r.Error = applyErr.Error()
+	}
+	return r, nil
+}
+
+// recordApply writes the audit record of an apply of the rendered cluster and,
+// if it succeeded, its snapshot to roll back to. Failing to record does not
+// fail the apply.
+func recordApply(file, tfile string, cluster *types.Cluster, s *State, updated bool, applyErr error) {
+	rec, err := clusterAuditRecord(file, tfile, cluster, s, updated, applyErr)
+	if err != nil {
+		logrus.Warnf("Could not create audit record: %v", err)
+		return
+	}
+	if err := writeAuditRecord(cluster, rec); err != nil {
+		logrus.Warnf("Could not write audit record: %v", err)
+	}
+	if applyErr != nil {
+		return
+	}
+	if err := writeSnapshot(cluster, rec.ID, tfile); err != nil {
+		logrus.Warnf("Could not write snapshot: %v", err)
+	}
+}
+
+func writeAuditRecord(cluster *types.Cluster, r *AuditRecord) error {
+	p, err := historyPath(cluster)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return p.Join(r.ID+".json").WriteFile(bytes.NewReader(b), nil)
+}
+
+func readAuditRecord(p vfs.Path) (*AuditRecord, error) {
+	b, err := p.ReadFile()
+	if err != nil {
+		return nil, err
+	}
+	r := &AuditRecord{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("could not read audit record %v: %v", p.Path(), err)
+	}
+	return r, nil
+}
+
+// listAuditRecords returns the cluster's audit records, oldest first
+func listAuditRecords(cluster *types.Cluster) ([]*AuditRecord, error) {
+	p, err := historyPath(cluster)
+	if err != nil {
+		return nil, err
+	}
+	files, err := p.ReadDir()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	records := []*AuditRecord{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Base(), ".json") {
+			continue
+		}
+		r, err := readAuditRecord(f)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+// History lists the cluster's audit records, or shows the record with the given id
+func History(ctx context.Context, file, id string) error {
+	cluster, err := expandKopsCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		p, err := historyPath(cluster)
+		if err != nil {
+			return err
+		}
+		r, err := readAuditRecord(p.Join(id + ".json"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no record %v for cluster %v", id, cluster.Name)
+			}
+			return err
+		}
+		printAuditRecord(r)
+		return nil
+	}
+
+	records, err := listAuditRecords(cluster)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tUSER\tHOST\tGIT COMMIT\tUPDATED\tERROR")
+	for _, r := range records {
+		commit := r.GitCommit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		if r.GitDirty {
+			commit += "+dirty"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.ID, r.Kind, r.User, r.Host, commit, r.Updated, r.Error)
+	}
+	return w.Flush()
+}
+
+func printAuditRecord(r *AuditRecord) {
+	fmt.Printf("ID:         %v\n", r.ID)
+	fmt.Printf("Kind:       %v\n", r.Kind)
+	fmt.Printf("Cluster:    %v\n", r.Cluster)
+	fmt.Printf("Time:       %v\n", r.Time.Format(time.RFC3339))
+	fmt.Printf("User:       %v@%v\n", r.User, r.Host)
+	fmt.Printf("wk:         %v\n", r.BuildSha)
+	fmt.Printf("Git commit: %v (dirty: %v)\n", r.GitCommit, r.GitDirty)
+	fmt.Printf("Spec hash:  %v\n", r.SpecHash)
+	fmt.Printf("Updated:    %v\n", r.Updated)
+	if r.Error != "" {
+		fmt.Printf("Error:      %v\n", r.Error)
+	}
+	if r.ClusterDiff != "" {
+		fmt.Printf("\nCluster changed:\n%v\n", r.ClusterDiff)
+	}
+	names := []string{}
+	for name := range r.InstanceGroupDiffs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("\nInstance Group %v changed:\n%v\n", name, r.InstanceGroupDiffs[name])
+	}
+	if len(r.Channels) > 0 {
+		fmt.Printf("\nChannels:\n")
+		files := []string{}
+		for f := range r.Channels {
+			files = append(files, f)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			fmt.Printf("  %v %v\n", r.Channels[f], f)
+		}
+	}
+}
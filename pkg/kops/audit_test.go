@@ -0,0 +1,47 @@
+package kops
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestAuditRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cluster := &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "file://" + dir}},
+	}
+	s := newState()
+	s.Cluster.DiffText = "~ spec.kubernetesVersion: 1.12.7 -> 1.13.5"
+	s.InstanceGroups["nodes"] = ObjectState{}
+
+	for _, id := range []string{"b", "a"} {
+		r, err := clusterAuditRecord("", "", cluster, s, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ID = id
+		if err := writeAuditRecord(cluster, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := listAuditRecords(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != "a" || records[1].ID != "b" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if records[0].ClusterDiff != s.Cluster.DiffText || len(records[0].InstanceGroupDiffs) != 0 || !records[0].Updated {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+}
@@ -0,0 +1,158 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// kopsBinaryEnv is the variable in the kops environment naming the kops
+// binary selected for the cluster
+const kopsBinaryEnv = "WK_KOPS_BINARY"
+
+// kopsVersionRe matches the output of `kops version`, e.g. "Version 1.11.1 (git-0f2aa8d30)"
+var kopsVersionRe = regexp.MustCompile(`Version\s+v?([0-9][^\s]*)`)
+
+// kopsCommand runs kops with the binary selected in env
+func kopsCommand(ctx context.Context, env []string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, envKopsBinary(env), args...)
+	cmd.Env = env
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// envKopsBinary returns the kops binary selected in env
+func envKopsBinary(env []string) string {
+	bin := "kops"
+	for _, e := range env {
+		if strings.HasPrefix(e, kopsBinaryEnv+"=") {
+			bin = strings.TrimPrefix(e, kopsBinaryEnv+"=")
+		}
+	}
+	return bin
+}
+
+// kopsBinary returns the kops binary satisfying the version required by the
+// cluster, or by the workspace if the cluster does not set one. The kops on
+// PATH is preferred, then the newest matching binary in the workspace's
+// KopsBinDir. Without a required version, kops on PATH is used.
+func kopsBinary(ctx context.Context, file string, cluster *types.Cluster) (string, error) {
+	conf, confErr := util.GetConfig(file)
+	required := cluster.Kops.Version
+	if required == "" && confErr == nil {
+		required = conf.KopsVersion
+	}
+	if required == "" {
+		return "kops", nil
+	}
+	if confErr != nil {
+		return "", confErr
+	}
+	versionRange, err := semver.ParseRange(required)
+	if err != nil {
+		return "", fmt.Errorf("invalid kops version %q for cluster %v: %v", required, cluster.Name, err)
+	}
+
+	found := []string{}
+	installed, err := kopsVersion(ctx, "kops")
+	if err == nil {
+		if versionRange(installed) {
+			logrus.Debugf("Using kops %v on PATH for cluster %v.", installed, cluster.Name)
+			return "kops", nil
+		}
+		found = append(found, fmt.Sprintf("%v on PATH", installed))
+	} else {
+		logrus.Debugf("Could not detect kops version on PATH: %v", err)
+	}
+
+	if conf.KopsBinDir != "" {
+		dir := conf.KopsBinDir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(conf.ContextDir, dir)
+		}
+		binaries, err := versionedBinaries(dir)
+		if err != nil {
+			return "", err
+		}
+		versions := make([]semver.Version, 0, len(binaries))
+		for v := range binaries {
+			versions = append(versions, semver.MustParse(v))
+		}
+		semver.Sort(versions)
+		for i := len(versions) - 1; i >= 0; i-- {
+			if versionRange(versions[i]) {
+				bin := binaries[versions[i].String()]
+				logrus.Infof("Using kops %v (%v) for cluster %v.", versions[i], bin, cluster.Name)
+				return bin, nil
+			}
+		}
+		if len(versions) > 0 {
+			names := make([]string, len(versions))
+			for i, v := range versions {
+				names[i] = v.String()
+			}
+			found = append(found, fmt.Sprintf("%v in %v", strings.Join(names, ", "), dir))
+		}
+	}
+
+	if len(found) == 0 {
+		return "", fmt.Errorf("cluster %v requires kops %v but no kops binary was found", cluster.Name, required)
+	}
+	return "", fmt.Errorf("cluster %v requires kops %v but only found %v", cluster.Name, required, strings.Join(found, "; "))
+}
+
+// kopsVersion runs `kops version` with the given binary
+func kopsVersion(ctx context.Context, bin string) (semver.Version, error) {
+	out := &bytes.Buffer{}
+	vCmd := exec.CommandContext(ctx, bin, "version")
+	vCmd.Stdout = out
+	if err := vCmd.Run(); err != nil {
+		return semver.Version{}, err
+	}
+	m := kopsVersionRe.FindStringSubmatch(out.String())
+	if m == nil {
+		return semver.Version{}, fmt.Errorf("could not parse kops version from %q", strings.TrimSpace(out.String()))
+	}
+	return semver.ParseTolerant(m[1])
+}
+
+// versionedBinaries finds kops binaries in dir named `kops-<version>` or
+// `<version>/kops`, keyed by version
+func versionedBinaries(dir string) (map[string]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read kops binary dir: %v", err)
+	}
+	binaries := map[string]string{}
+	for _, e := range entries {
+		name, path := e.Name(), filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			path = filepath.Join(path, "kops")
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+		} else if strings.HasPrefix(name, "kops-") {
+			name = strings.TrimPrefix(name, "kops-")
+		} else {
+			continue
+		}
+		v, err := semver.ParseTolerant(name)
+		if err != nil {
+			continue
+		}
+		binaries[v.String()] = path
+	}
+	return binaries, nil
+}
@@ -0,0 +1,63 @@
+package kops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestKopsBinary(t *testing.T) {
+	_, cleanup := stubKops(t, `echo "Version 1.11.1 (git-0f2aa8d30)"`)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "wk-workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, ".wk.yaml"), []byte("KopsBinDir: bin\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"bin/kops-1.12.0", "bin/kops-1.12.2", "bin/1.13.0/kops", "bin/README"} {
+		p = filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "cluster.jsonnet")
+
+	for _, tc := range []struct {
+		version string
+		want    string
+		err     string
+	}{
+		{"", "kops", ""},
+		{"1.11.1", "kops", ""},
+		{">=1.12.0 <1.13.0", filepath.Join(dir, "bin/kops-1.12.2"), ""},
+		{"1.13.x", filepath.Join(dir, "bin/1.13.0/kops"), ""},
+		{"1.10.0", "", "only found 1.11.1 on PATH; 1.12.0, 1.12.2, 1.13.0 in"},
+		{"latest", "", "invalid kops version"},
+	} {
+		cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{Version: tc.version}}
+		bin, err := kopsBinary(context.Background(), file, cluster)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: expected error containing %q, got %v", tc.version, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.version, err)
+		} else if bin != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.version, bin, tc.want)
+		}
+	}
+}
@@ -12,8 +12,11 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/sirupsen/logrus"
+
 	"github.com/wish/wk/pkg/jsonnet"
 	"github.com/wish/wk/pkg/opa"
+	"github.com/wish/wk/pkg/types"
 	"github.com/wish/wk/pkg/util"
 	_ "k8s.io/kops/util/pkg/vfs"
 )
@@ -61,20 +64,53 @@ const addonStr = `     - manifest: %v
 `
 
 func ChannelsApply(ctx context.Context, file, dryFile string, opaQuery *opa.OPA) error {
-	conf, err := util.GetConfig(file)
+	cluster, tfile, chItems, err := renderChannels(ctx, file, dryFile, opaQuery)
 	if err != nil {
 		return err
 	}
+
+	if dryFile != "" {
+		if r, err := newAuditRecord("channels", file, tfile, cluster); err != nil {
+			logrus.Warnf("Could not create audit record: %v", err)
+		} else {
+			r.Channels = map[string]string{}
+			for _, it := range chItems {
+				r.Channels[it.path] = it.hash
+			}
+			if err := writeAuditRecord(cluster, r); err != nil {
+				logrus.Warnf("Could not write audit record: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// renderChannels renders the cluster's channels. If dryFile is set, the
+// rendered apps and channel.yaml are written into it.
+func renderChannels(ctx context.Context, file, dryFile string, opaQuery *opa.OPA) (*types.Cluster, string, channelItems, error) {
+	conf, err := util.GetConfig(file)
+	if err != nil {
+		return nil, "", nil, err
+	}
 	ctxDir := conf.ContextDir
 
-	cluster, _, err := jsonnet.ExpandCluster(ctx, file)
+	cluster, tfile, err := jsonnet.ExpandCluster(ctx, file)
 	if err != nil {
-		return err
+		return nil, "", nil, err
 	}
 	if cluster.Kops == nil {
-		return fmt.Errorf("kops configuration is missing")
+		return nil, "", nil, fmt.Errorf("kops configuration is missing")
+	}
+	chItems, err := renderChannelApps(ctx, file, ctxDir, cluster.Kops.Channels, dryFile, opaQuery)
+	if err != nil {
+		return nil, "", nil, err
 	}
+	return cluster, tfile, chItems, nil
+}
 
+// renderChannelApps renders the apps of channels. If dryFile is set, the
+// rendered apps and channel.yaml are written into it.
+func renderChannelApps(ctx context.Context, file, ctxDir string, channels []types.Channel, dryFile string, opaQuery *opa.OPA) (channelItems, error) {
 	chItemsMu := sync.Mutex{}
 	chItems := channelItems{}
 	mkdirMu := sync.Mutex{}
@@ -84,27 +120,12 @@ func ChannelsApply(ctx context.Context, file, dryFile string, opaQuery *opa.OPA)
 		mu:    sync.Mutex{},
 	}
 
-	for _, channel := range cluster.Kops.Channels {
-		var regex *regexp.Regexp
-		if channel.FileWhitelistRegexp != nil {
-			regex, err = regexp.Compile(*channel.FileWhitelistRegexp)
-			if err != nil {
-				return err
-			}
-		} else {
-			regex = regexp.MustCompile("\\.jsonnet$")
-		}
-
+	for _, channel := range channels {
 		if channel.Folder != "" {
 			fold := filepath.Join(ctxDir, channel.Folder)
-			files := []string{}
-			if err = filepath.Walk(fold, func(path string, info os.FileInfo, err error) error {
-				if regex.Match([]byte(path)) {
-					files = append(files, path)
-				}
-				return nil
-			}); err != nil {
-				return err
+			files, err := channelFiles(ctxDir, channel)
+			if err != nil {
+				return nil, err
 			}
 
 			wg := &sync.WaitGroup{}
@@ -163,7 +184,7 @@ func ChannelsApply(ctx context.Context, file, dryFile string, opaQuery *opa.OPA)
 					fmt.Printf("%v\n", err)
 
 				}
-				return fmt.Errorf("%v errors encountered compiling channel %v", len(errs), channel.Name)
+				return nil, fmt.Errorf("%v errors encountered compiling channel %v", len(errs), channel.Name)
 			}
 		} else {
 			// TODO(tvi): Add more supported types.
@@ -172,7 +193,7 @@ func ChannelsApply(ctx context.Context, file, dryFile string, opaQuery *opa.OPA)
 
 	if dryFile != "" {
 		if err := os.MkdirAll(filepath.Dir(filepath.Join(dryFile, "channel.yaml")), os.ModePerm); err != nil {
-			return err
+			return nil, err
 		}
 		sort.Sort(chItems)
 
@@ -184,9 +205,33 @@ func ChannelsApply(ctx context.Context, file, dryFile string, opaQuery *opa.OPA)
 		if err := ioutil.WriteFile(filepath.Join(dryFile, "channel.yaml"), []byte(out), 0644); err != nil {
 			log.Fatal(err)
 		}
+	}
+	return chItems, nil
+}
+
+// channelFiles lists the app files in the folder of a channel
+func channelFiles(ctxDir string, channel types.Channel) ([]string, error) {
+	var regex *regexp.Regexp
+	if channel.FileWhitelistRegexp != nil {
+		var err error
+		regex, err = regexp.Compile(*channel.FileWhitelistRegexp)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		regex = regexp.MustCompile("\\.jsonnet$")
+	}
+
+	files := []string{}
+	if err := filepath.Walk(filepath.Join(ctxDir, channel.Folder), func(path string, info os.FileInfo, err error) error {
+		if regex.Match([]byte(path)) {
+			files = append(files, path)
+		}
 		return nil
+	}); err != nil {
+		return nil, err
 	}
-	return nil
+	return files, nil
 }
 
 func pathToName(path string) string {
@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -19,116 +20,372 @@ import (
 	"github.com/wish/wk/pkg/util"
 )
 
-func ClusterApply(ctx context.Context, file, dryFile string, forceUpdate, noUpdate, preview bool, opaQuery *opa.OPA) error {
-	cluster, tfile, err := jsonnet.ExpandCluster(ctx, file)
-	if err != nil {
+// ClusterApplyOptions configures a ClusterApply run
+type ClusterApplyOptions struct {
+	DryFile     string
+	ForceUpdate bool
+	NoUpdate    bool
+	Preview     bool
+	// DiffFile, if set, receives the structural diffs as a JSON document
+	DiffFile string
+	// ReportFile, if set, receives the report of the run as a JSON document
+	ReportFile string
+	// RollingUpdate, if set, rolls the changed instance groups after an update
+	RollingUpdate *RollingUpdateOptions
+	// Validate waits up to ValidateTimeout for the cluster to validate after the update
+	Validate        bool
+	ValidateTimeout time.Duration
+	// Create bootstraps the cluster if it is missing from the state store
+	Create bool
+	// AutoApprove applies the changes without asking. Otherwise the pending
+	// changes are shown and confirmation is read from In.
+	AutoApprove bool
+	In          io.Reader
+	// Timeouts bounds the steps of the run
+	Timeouts StepTimeouts
+
+	report *Report
+	retry  map[string]*retryPolicy
+}
+
+func ClusterApply(ctx context.Context, file string, opts ClusterApplyOptions, opaQuery *opa.OPA) (err error) {
+	if opts.DryFile == "" {
+		opts.report = newReport(file, opts.Preview)
+		defer func() { err = opts.report.finish(err, opts.ReportFile) }()
+	}
+	r := opts.report
+
+	var cluster *types.Cluster
+	var tfile string
+	if err := runStep(ctx, opts, "render", opts.Timeouts.Render, func(ctx context.Context) (err error) {
+		cluster, tfile, err = jsonnet.ExpandCluster(ctx, file)
 		return err
+	}); err != nil {
+		return err
+	}
+	if r != nil {
+		r.Cluster = cluster.Name
 	}
 
 	if opaQuery != nil {
-		accepted, issues, err2 := opaQuery.RunFile(tfile)
-		if err2 != nil {
-			return err2
-		}
-		if !accepted {
-			for _, issue := range issues {
-				logrus.Errorf(issue)
+		if err := runStep(ctx, opts, "opa", 0, func(ctx context.Context) error {
+			accepted, issues, err := opaQuery.RunFile(tfile)
+			if err != nil {
+				return err
+			}
+			r.setOPA(accepted, issues)
+			if !accepted {
+				for _, issue := range issues {
+					logrus.Errorf(issue)
+				}
+				return fmt.Errorf("Cluster failed OPA validation")
 			}
-			return fmt.Errorf("Cluster failed OPA validation")
+			return nil
+		}); err != nil {
+			return err
 		}
 	}
 
-	if cluster.Kops == nil {
-		return fmt.Errorf("kops configuration is missing")
+	if err := runStep(ctx, opts, "validate-spec", 0, func(ctx context.Context) error { return validateSpec(file, cluster) }); err != nil {
+		return err
 	}
-	if dryFile != "" {
-		if err := os.MkdirAll(filepath.Dir(dryFile), os.ModePerm); err != nil {
+	if opts.DryFile != "" {
+		if err := os.MkdirAll(filepath.Dir(opts.DryFile), os.ModePerm); err != nil {
 			return err
 		}
 
-		return CopyFile(tfile, dryFile)
+		return CopyFile(tfile, opts.DryFile)
 	}
 
-	s := newState()
-	sb, err := json.Marshal(s)
+	if !opts.Preview {
+		lockCtx, release, err := acquireLock(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		defer release()
+		ctx = lockCtx
+	}
+	return applyCluster(ctx, file, cluster, tfile, opts)
+}
+
+// applyCluster edits the rendered cluster into the state store and updates it.
+// Unless previewing, the caller must hold the cluster's lock.
+func applyCluster(ctx context.Context, file string, cluster *types.Cluster, tfile string, opts ClusterApplyOptions) (err error) {
+	r := opts.report
+	if r != nil {
+		r.Cluster = cluster.Name
+	}
+	target, err := clusterTarget(cluster)
 	if err != nil {
 		return err
 	}
-	statefile, err := util.WriteTempFile(sb)
+	if opts.retry == nil {
+		if opts.retry, err = retryPolicies(file); err != nil {
+			return err
+		}
+	}
+	kopsEnv, err := clusterEnv(ctx, file, cluster)
 	if err != nil {
 		return err
 	}
 
-	kopsEnv := os.Environ()
-	for k, v := range cluster.Kops.Env {
-		kopsEnv = append(kopsEnv, fmt.Sprintf("%v=%v", k, v))
+	var exists bool
+	if err := runStep(ctx, opts, "check-exists", 0, func(ctx context.Context) (err error) {
+		exists, err = clusterExists(ctx, cluster.Name, kopsEnv)
+		return err
+	}); err != nil {
+		return err
 	}
-
-	ex, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("could not get executable: %v", err)
+	if !exists && !opts.Create {
+		return fmt.Errorf("cluster %v does not exist in state store, use --create to create it", cluster.Name)
+	}
+	if r != nil {
+		r.Created = !exists
 	}
 
-	logrus.Infoln("Editing cluster.")
-	mode := "normal"
-	if preview {
-		mode = "preview"
+	var statuses []*secretStatus
+	if len(cluster.Kops.Secrets) > 0 {
+		if err := runStep(ctx, opts, "read-secrets", 0, func(ctx context.Context) (err error) {
+			statuses, err = secretStatuses(file, cluster, exists)
+			return err
+		}); err != nil {
+			return err
+		}
 	}
 
-	eCmd := exec.CommandContext(ctx, "kops", "edit", "cluster", "--name="+cluster.Name)
-	eCmd.Stdout, eCmd.Stderr = os.Stdout, os.Stderr
-	eCmd.Env = append(kopsEnv, fmt.Sprintf("%v=%v %v %v %v %v %v", "EDITOR", ex, "cluster-edit", file, tfile, statefile, mode))
-	if err = eCmd.Run(); err != nil {
-		return fmt.Errorf("could not edit cluster: %v", err)
+	// Previews and confirmations are computed without writing anything
+	if opts.Preview || !opts.AutoApprove {
+		s, err := pendingChanges(ctx, cluster, file, tfile, kopsEnv, exists, opts)
+		if err != nil {
+			return err
+		}
+		secretsChanged, err := applySecrets(ctx, cluster, kopsEnv, statuses, true)
+		if err != nil {
+			return err
+		}
+		r.setState(s)
+		if r != nil {
+			r.SecretsChanged = secretsChanged
+		}
+
+		if opts.Preview {
+			if e := printCostEstimate(os.Stdout, file, cluster, s); e != nil && r != nil {
+				r.Cost = e
+			}
+			if target == TargetTerraform && exists {
+				if err := previewTerraform(ctx, file, cluster, kopsEnv); err != nil {
+					return err
+				}
+			}
+			if opts.DiffFile != "" {
+				if err := s.writeDiffs(opts.DiffFile); err != nil {
+					return fmt.Errorf("could not write diffs: %v", err)
+				}
+			}
+			logrus.Infoln("Not performing update.")
+			return nil
+		}
+		if s.requiresUpdate() || secretsChanged || !exists {
+			if err := confirmApply(opts.In, cluster.Name, s, exists); err != nil {
+				return err
+			}
+		}
 	}
 
-	// This shouldn't be made concurrent, since kops as a tool cannot be run concurrently.
-	// I tried. kops ended up overwriting one instancegroup with another
-	for _, ig := range cluster.Kops.InstanceGroups {
-		logrus.Infoln("Editing instance group:", ig.Name)
-
-		func(ig types.InstanceGroup) {
-			// TODO(akursell): This is usually pointless
-			igCmd := exec.CommandContext(ctx, "kops", "create", "ig", "--name="+cluster.Name, ig.Name)
-			igCmd.Env = append(kopsEnv, fmt.Sprintf("%v=%v %v %v %v %v %v %v", "EDITOR", ex, "cluster-edit-ig", file, tfile, statefile, mode, ig.Name))
-			createErr := igCmd.Run()
-			if createErr == nil {
-				s.Cluster.UpdateRequired = true
+	// From the first write to the state store on, the apply is recorded in
+	// the history even if it fails partway through
+	var s *State
+	recorded := false
+	defer func() {
+		if !recorded {
+			recordApply(file, tfile, cluster, s, false, err)
+		}
+	}()
+
+	if !exists {
+		if err := runStep(ctx, opts, "create", 0, func(ctx context.Context) error { return createCluster(ctx, cluster, kopsEnv) }); err != nil {
+			return err
+		}
+		// kops create cluster may have stored secrets, e.g. the admin SSH key
+		if len(statuses) > 0 {
+			if err := runStep(ctx, opts, "read-secrets", 0, func(ctx context.Context) (err error) {
+				statuses, err = secretStatuses(file, cluster, true)
+				return err
+			}); err != nil {
+				return err
 			}
+		}
+	}
 
-			igCmd = exec.CommandContext(ctx, "kops", "edit", "ig", "--name="+cluster.Name, ig.Name)
-			igCmd.Env = append(kopsEnv, fmt.Sprintf("%v=%v %v %v %v %v %v %v", "EDITOR", ex, "cluster-edit-ig", file, tfile, statefile, mode, ig.Name))
-			igCmd.Stdout, igCmd.Stderr = os.Stdout, os.Stderr
-			err = igCmd.Run()
-		}(ig)
-		if err != nil {
+	secretsChanged := false
+	if len(statuses) > 0 {
+		if err := runStep(ctx, opts, "secrets", 0, func(ctx context.Context) (err error) {
+			secretsChanged, err = applySecrets(ctx, cluster, kopsEnv, statuses, false)
+			return err
+		}); err != nil {
 			return err
 		}
+		if r != nil {
+			r.SecretsChanged = secretsChanged
+		}
+	}
+
+	s, err = runEdits(ctx, cluster, file, tfile, "normal", kopsEnv, opts)
+	if err != nil {
+		return err
+	}
+	r.setState(s)
+
+	if opts.DiffFile != "" {
+		if err := s.writeDiffs(opts.DiffFile); err != nil {
+			return fmt.Errorf("could not write diffs: %v", err)
+		}
 	}
 
-	s = getState(statefile)
-	if !preview && !noUpdate && (s.requiresUpdate() || forceUpdate) {
+	updated := false
+	if !opts.NoUpdate && (s.requiresUpdate() || secretsChanged || opts.ForceUpdate || !exists) {
 		logrus.Infoln("Update is required. Issuing update.")
 
-		uCmd := exec.CommandContext(ctx, "kops", "update", "cluster", "--name="+cluster.Name, "-v1", "--yes", "--create-kube-config=false")
-		uCmd.Stdout, uCmd.Stderr = os.Stdout, os.Stderr
-		uCmd.Env = kopsEnv
-		if err = uCmd.Run(); err != nil {
-			return fmt.Errorf("could not update cluster: %v", err)
+		err = runStep(ctx, opts, "update", opts.Timeouts.Update, func(ctx context.Context) error { return updateCluster(ctx, file, cluster, kopsEnv) })
+		updated = err == nil
+		if r != nil {
+			r.UpdateIssued = updated
 		}
 	} else {
 		logrus.Infoln("Not performing update.")
 	}
 
+	recorded = true
+	recordApply(file, tfile, cluster, s, updated, err)
+	if err != nil {
+		return err
+	}
+
+	if target == TargetTerraform && (opts.RollingUpdate != nil || opts.Validate) {
+		logrus.Warnf("Cluster %v uses the terraform target; apply the terraform output before rolling update and validation.", cluster.Name)
+		return nil
+	}
+	if updated && opts.RollingUpdate != nil {
+		if err := runStep(ctx, opts, "rolling-update", 0, func(ctx context.Context) error {
+			return rollingUpdate(ctx, cluster, kopsEnv, rollingUpdateIGs(cluster, s), *opts.RollingUpdate)
+		}); err != nil {
+			return err
+		}
+	}
+	if opts.Validate {
+		return runStep(ctx, opts, "validate", 0, func(ctx context.Context) error { return validateCluster(ctx, cluster, kopsEnv, opts.ValidateTimeout) })
+	}
 	return nil
 }
 
+// runEdits runs `kops edit` on the cluster and all its instance groups with wk
+// itself as the editor and returns the resulting state, on errors the state of
+// the edits made so far. In preview mode nothing is written to the state
+// store. Each edit is a step of the run configured by opts.
+func runEdits(ctx context.Context, cluster *types.Cluster, file, tfile, mode string, kopsEnv []string, opts ClusterApplyOptions) (*State, error) {
+	s := newState()
+	sb, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	statefile, err := util.WriteTempFile(sb)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(statefile)
+
+	ex, err := os.Executable()
+	if err != nil {
+		return getState(statefile), fmt.Errorf("could not get executable: %v", err)
+	}
+
+	err = runStep(ctx, opts, "edit-cluster", opts.Timeouts.EditCluster, func(ctx context.Context) error {
+		logrus.Infoln("Editing cluster.")
+		eCmd := kopsCommand(ctx, kopsEnv, "edit", "cluster", "--name="+cluster.Name)
+		eCmd.Stdout, eCmd.Stderr = stepOutput(ctx)
+		eCmd.Env = append(kopsEnv, fmt.Sprintf("%v=%v %v %v %v %v %v", "EDITOR", ex, "cluster-edit", file, tfile, statefile, mode))
+		if err := eCmd.Run(); err != nil {
+			return fmt.Errorf("could not edit cluster: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return getState(statefile), err
+	}
+
+	// This shouldn't be made concurrent, since kops as a tool cannot be run concurrently.
+	// I tried. kops ended up overwriting one instancegroup with another
+	for _, ig := range cluster.Kops.InstanceGroups {
+		exists := false
+		err := runStep(ctx, opts, "check-exists/"+ig.Name, 0, func(ctx context.Context) (err error) {
+			exists, err = igExists(ctx, cluster.Name, ig.Name, kopsEnv)
+			return err
+		})
+		if err != nil {
+			return getState(statefile), err
+		}
+
+		igEditor := fmt.Sprintf("%v=%v %v %v %v %v %v %v", "EDITOR", ex, "cluster-edit-ig", file, tfile, statefile, mode, ig.Name)
+		if !exists {
+			// `kops create ig` would store the instance group even when previewing
+			if mode == "preview" {
+				logrus.Infof("Instance group %v does not exist and would be created.", ig.Name)
+				updateState(statefile, func(s *State) {
+					s.InstanceGroups[ig.Name] = addedState(ig.Value)
+				})
+				continue
+			}
+			// Creating is a step of its own, which is never retried
+			err := runStep(ctx, opts, "create-ig/"+ig.Name, opts.Timeouts.EditIG, func(ctx context.Context) error {
+				logrus.Infoln("Creating instance group:", ig.Name)
+				igCmd := kopsCommand(ctx, kopsEnv, "create", "ig", "--name="+cluster.Name, ig.Name)
+				igCmd.Env = append(kopsEnv, igEditor)
+				igCmd.Stdout, igCmd.Stderr = stepOutput(ctx)
+				if err := igCmd.Run(); err != nil {
+					return fmt.Errorf("could not create instance group %v: %w", ig.Name, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return getState(statefile), err
+			}
+		}
+
+		err = runStep(ctx, opts, "edit-ig/"+ig.Name, opts.Timeouts.EditIG, func(ctx context.Context) error {
+			logrus.Infoln("Editing instance group:", ig.Name)
+			igCmd := kopsCommand(ctx, kopsEnv, "edit", "ig", "--name="+cluster.Name, ig.Name)
+			igCmd.Env = append(kopsEnv, igEditor)
+			igCmd.Stdout, igCmd.Stderr = stepOutput(ctx)
+			if err := igCmd.Run(); err != nil {
+				return fmt.Errorf("could not edit instance group %v: %w", ig.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return getState(statefile), err
+		}
+		// The edit finds the instance group as just created, so it is
+		// recorded as added like in previews
+		if !exists {
+			updateState(statefile, func(s *State) {
+				s.InstanceGroups[ig.Name] = addedState(ig.Value)
+			})
+		}
+	}
+
+	return getState(statefile), nil
+}
+
 func ClusterEdit(ctx context.Context, args []string) error {
 	renderedJsonnet, stateFile, mode, outFile := args[1], args[2], args[3], args[4]
 
 	cluster, err := ReadClusterFile(renderedJsonnet)
 	if err != nil {
-		return nil
+		return err
+	}
+	rules, err := diffRules(args[0], cluster)
+	if err != nil {
+		return err
 	}
 
 	data, err := ioutil.ReadFile(outFile)
@@ -141,20 +398,22 @@ func ClusterEdit(ctx context.Context, args []string) error {
 		return err
 	}
 	newCluster := cluster.Kops.Cluster
-
-	// Preserve the timestamps and resource generation across applications. Otherwise it always shows a diff
-	if oldTime, ok := oldCluster["metadata"].(map[string]interface{})["creationTimestamp"]; ok {
-		newCluster["metadata"].(map[string]interface{})["creationTimestamp"] = oldTime
+	if newCluster == nil {
+		return fmt.Errorf("cluster spec is missing")
 	}
-	if oldGen, ok := oldCluster["metadata"].(map[string]interface{})["generation"]; ok {
-		newCluster["metadata"].(map[string]interface{})["generation"] = oldGen
+
+	oldDiff, newDiff, err := applyDiffRules(oldCluster, newCluster, rules.Cluster)
+	if err != nil {
+		return err
 	}
 
-	eq, diffText := diff(oldCluster, newCluster)
+	eq, diffText, changes := diff(oldDiff, newDiff)
 	updateState(stateFile, func(s *State) {
 		s.Cluster = ObjectState{
 			UpdateRequired: !eq,
 			DiffText:       diffText,
+			Changes:        changes,
+			Version:        objectVersion(data),
 		}
 	})
 	if !eq {
@@ -183,12 +442,20 @@ func ClusterEditIG(ctx context.Context, args []string) error {
 		return err
 	}
 
+	rules, err := diffRules(args[0], cluster)
+	if err != nil {
+		return err
+	}
+
 	var ptch map[string]interface{}
 	for _, ig := range cluster.Kops.InstanceGroups {
 		if ig.Name == igName {
 			ptch = ig.Value
 		}
 	}
+	if ptch == nil {
+		return fmt.Errorf("instance group %v is missing", igName)
+	}
 
 	data, err := ioutil.ReadFile(outFile)
 	if err != nil {
@@ -198,19 +465,19 @@ func ClusterEditIG(ctx context.Context, args []string) error {
 	if err = sigs_yaml.Unmarshal(data, &oldIG); err != nil {
 		return err
 	}
-	// Preserve the timestamps and resource generation across applications. Otherwise it always shows a diff
-	if oldTime, ok := oldIG["metadata"].(map[string]interface{})["creationTimestamp"]; ok {
-		ptch["metadata"].(map[string]interface{})["creationTimestamp"] = oldTime
-	}
-	if oldGen, ok := oldIG["metadata"].(map[string]interface{})["generation"]; ok {
-		ptch["metadata"].(map[string]interface{})["generation"] = oldGen
+
+	oldDiff, newDiff, err := applyDiffRules(oldIG, ptch, rules.InstanceGroups)
+	if err != nil {
+		return err
 	}
 
-	eq, diffText := diff(oldIG, ptch)
+	eq, diffText, changes := diff(oldDiff, newDiff)
 	updateState(stateFile, func(s *State) {
 		s.InstanceGroups[igName] = ObjectState{
 			UpdateRequired: !eq,
 			DiffText:       diffText,
+			Changes:        changes,
+			Version:        objectVersion(data),
 		}
 	})
 	if !eq {
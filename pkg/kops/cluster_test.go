@@ -0,0 +1,117 @@
+package kops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunEditsRecordsCreatedInstanceGroups(t *testing.T) {
+	// Only nodes-b is missing from the state store
+	log, cleanup := stubKops(t, `case "$*" in "get ig --name=test.k8s.local nodes-b") echo 'instancegroup "nodes-b" not found' >&2; exit 1;; esac`)
+	defer cleanup()
+
+	r := newReport("cluster.jsonnet", false)
+	s, err := runEdits(context.Background(), testCluster(), "cluster.jsonnet", "", "normal", os.Environ(), ClusterApplyOptions{report: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.requiresUpdate() {
+		t.Error("expected created instance group to require an update")
+	}
+	if ig, ok := s.InstanceGroups["nodes-b"]; !ok || !ig.UpdateRequired || len(ig.Changes) == 0 {
+		t.Errorf("expected nodes-b to be recorded as added, got %+v", s.InstanceGroups)
+	}
+	if _, ok := s.InstanceGroups["nodes-a"]; ok {
+		t.Errorf("expected existing nodes-a to be left to the edit, got %+v", s.InstanceGroups)
+	}
+
+	creates := []string{}
+	for _, call := range readCalls(t, log) {
+		if strings.HasPrefix(call, "create ig") {
+			creates = append(creates, call)
+		}
+	}
+	if want := "create ig --name=test.k8s.local nodes-b"; len(creates) != 1 || creates[0] != want {
+		t.Errorf("got creates %q, want %q", creates, want)
+	}
+
+	r.setState(s)
+	for _, o := range r.Objects {
+		if o.Name == "nodes-b" && o.Status != ObjectAdded {
+			t.Errorf("got status %v of nodes-b, want %v", o.Status, ObjectAdded)
+		}
+	}
+}
+
+func TestRunEditsDoesNotRetryCreate(t *testing.T) {
+	// Creating nodes-b is throttled after kops stored it
+	log, cleanup := stubKops(t, `case "$*" in
+"get ig --name=test.k8s.local nodes-b") echo 'instancegroup "nodes-b" not found' >&2; exit 1;;
+"create ig "*) echo 'RequestLimitExceeded' >&2; exit 1;;
+esac`)
+	defer cleanup()
+
+	policy, err := parseRetryPolicy(defaultRetryPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.backoff, policy.maxBackoff = 0, 0
+	policies := map[string]*retryPolicy{}
+	for step := range idempotentSteps {
+		policies[step] = policy
+	}
+	opts := ClusterApplyOptions{retry: policies}
+	if _, err := runEdits(context.Background(), testCluster(), "cluster.jsonnet", "", "normal", os.Environ(), opts); err == nil {
+		t.Fatal("expected create to fail")
+	}
+	creates := 0
+	for _, call := range readCalls(t, log) {
+		if strings.HasPrefix(call, "create ig") {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Errorf("expected create ig to run once, ran %v times", creates)
+	}
+}
+
+func TestApplyClusterRecordsFailedEdits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, ".wk.yaml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, tfile := filepath.Join(dir, "cluster.jsonnet"), filepath.Join(dir, "cluster.json")
+	if err := ioutil.WriteFile(tfile, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, cleanup := stubKops(t, `case "$*" in "edit ig --name=test.k8s.local nodes-b") exit 1;; esac`)
+	defer cleanup()
+
+	cluster := testCluster()
+	cluster.Kops.Env = map[string]string{"KOPS_STATE_STORE": "file://" + filepath.Join(dir, "store")}
+	err = applyCluster(context.Background(), file, cluster, tfile, ClusterApplyOptions{AutoApprove: true})
+	if err == nil || !strings.Contains(err.Error(), "nodes-b") {
+		t.Fatalf("expected editing nodes-b to fail, got %v", err)
+	}
+
+	// The cluster and nodes-a were already written
+	records, err := listAuditRecords(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Updated || !strings.Contains(records[0].Error, "nodes-b") {
+		t.Fatalf("expected a record of the failed apply, got %+v", records)
+	}
+	// Failed applies cannot be rolled back to
+	if _, _, err := readSnapshot(cluster, records[0].ID); err == nil || !strings.Contains(err.Error(), "no snapshot") {
+		t.Errorf("expected no snapshot of the failed apply, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package kops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+// pendingChanges returns the changes applying the cluster would make without
+// writing them to the state store
+func pendingChanges(ctx context.Context, cluster *types.Cluster, file, tfile string, kopsEnv []string, exists bool, opts ClusterApplyOptions) (*State, error) {
+	if exists {
+		return runEdits(ctx, cluster, file, tfile, "preview", kopsEnv, opts)
+	}
+	logrus.Infof("Cluster %v does not exist and would be created.", cluster.Name)
+	s := previewCreate(cluster)
+	logrus.Info(s.renderDiffs())
+	return s, nil
+}
+
+// confirmApply shows the pending changes of the cluster and asks on in whether
+// to apply them. Anything but yes declines.
+func confirmApply(in io.Reader, name string, s *State, exists bool) error {
+	if in == nil {
+		return fmt.Errorf("refusing to apply changes to %v without confirmation", name)
+	}
+	fmt.Printf("\nPending changes to %v:\n\n%v\n", name, s.renderDiffs())
+	if !exists {
+		fmt.Printf("Cluster %v will be created.\n", name)
+	}
+	ok, err := confirm(in, fmt.Sprintf("Apply these changes to %v?", name))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("changes to %v not applied", name)
+	}
+	return nil
+}
+
+// confirm asks question on in and returns whether it was answered with yes
+func confirm(in io.Reader, question string) (bool, error) {
+	fmt.Printf("%v [y/N]: ", question)
+	answer, err := readLine(in)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// readLine reads a line from in without reading past it, so that in can be
+// asked again
+func readLine(in io.Reader) (string, error) {
+	line := []byte{}
+	b := make([]byte, 1)
+	for {
+		n, err := in.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package kops
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfirmApply(t *testing.T) {
+	s := newState()
+	s.InstanceGroups["nodes"] = ObjectState{UpdateRequired: true, DiffText: "~ spec.maxSize: 3 -> 5"}
+
+	for answer, approved := range map[string]bool{
+		"y\n":   true,
+		"YES\n": true,
+		"n\n":   false,
+		"\n":    false,
+		"":      false,
+		"yep\n": false,
+	} {
+		err := confirmApply(strings.NewReader(answer), "test.k8s.local", s, true)
+		if (err == nil) != approved {
+			t.Errorf("answer %q: got %v, approved %v", answer, err, approved)
+		}
+	}
+
+	if err := confirmApply(nil, "test.k8s.local", s, true); err == nil {
+		t.Error("expected refusal without input")
+	}
+}
@@ -0,0 +1,359 @@
+package kops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/wish/wk/pkg/jsonnet"
+	"github.com/wish/wk/pkg/specdiff"
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// defaultPriceTable is the price table file in the workspace used unless the
+// workspace configures another
+const defaultPriceTable = "prices.yaml"
+
+// defaultHoursPerMonth is the average number of hours in a month
+const defaultHoursPerMonth = 730
+
+// defaultRootVolumeSizes are the root volume sizes in GB kops uses by role
+// when an instance group does not set one
+var defaultRootVolumeSizes = map[string]int32{"Master": 64, "Node": 128, "Bastion": 32}
+
+// defaultRootVolumeType is the root volume type kops uses on AWS
+const defaultRootVolumeType = "gp2"
+
+// PriceTable holds the prices used to estimate cluster costs
+type PriceTable struct {
+	// Currency is shown with the estimates, e.g. USD
+	Currency string
+	// HoursPerMonth defaults to 730
+	HoursPerMonth float64
+	// MachineTypes are hourly instance prices by machine type
+	MachineTypes map[string]float64
+	// Volumes are monthly prices of a GB of root volume by volume type
+	Volumes map[string]float64
+}
+
+// CostRange is a monthly cost at the minimum and maximum size of instance groups
+type CostRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (r CostRange) add(o CostRange) CostRange {
+	return CostRange{Min: r.Min + o.Min, Max: r.Max + o.Max}
+}
+
+func (r CostRange) sub(o CostRange) CostRange {
+	return CostRange{Min: r.Min - o.Min, Max: r.Max - o.Max}
+}
+
+// InstanceGroupCost is the estimated monthly cost of an instance group and
+// the change of it caused by pending changes
+type InstanceGroupCost struct {
+	Name        string    `json:"name"`
+	MachineType string    `json:"machineType"`
+	MinSize     int32     `json:"minSize"`
+	MaxSize     int32     `json:"maxSize"`
+	Monthly     CostRange `json:"monthly"`
+	Delta       CostRange `json:"delta"`
+	// Unknown is set if the current or previous machine type or root volume
+	// type is missing from the price table. Monthly and Delta are not
+	// estimated then.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// CostEstimate is the estimated monthly cost of a cluster's instance groups
+type CostEstimate struct {
+	Cluster        string              `json:"cluster"`
+	Currency       string              `json:"currency"`
+	InstanceGroups []InstanceGroupCost `json:"instanceGroups"`
+	// Total and Delta leave out instance groups of unknown machine types or
+	// root volume types
+	Total CostRange `json:"total"`
+	Delta CostRange `json:"delta"`
+	// UnknownMachineTypes are the machine types missing from the price table
+	UnknownMachineTypes []string `json:"unknownMachineTypes"`
+	// UnknownVolumeTypes are the root volume types missing from the price table
+	UnknownVolumeTypes []string `json:"unknownVolumeTypes"`
+}
+
+// ClusterCost renders the cluster file and estimates the monthly cost of its
+// instance groups. Unless offline, the cost change of the changes pending
+// against the state store is estimated as well.
+func ClusterCost(ctx context.Context, file string, offline bool, out io.Writer, asJSON bool) error {
+	prices, err := loadPriceTable(file)
+	if err != nil {
+		return err
+	}
+	cluster, tfile, err := jsonnet.ExpandCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	if cluster.Kops == nil {
+		return fmt.Errorf("kops configuration is missing")
+	}
+
+	var s *State
+	if !offline {
+		kopsEnv, err := clusterEnv(ctx, file, cluster)
+		if err != nil {
+			return err
+		}
+		exists, err := clusterExists(ctx, cluster.Name, kopsEnv)
+		if err != nil {
+			return err
+		}
+		if s, err = pendingChanges(ctx, cluster, file, tfile, kopsEnv, exists, ClusterApplyOptions{}); err != nil {
+			return err
+		}
+	}
+
+	estimate, err := estimateCost(cluster, s, prices)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		b, err := json.MarshalIndent(estimate, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(b))
+		return err
+	}
+	printCost(out, estimate)
+	return nil
+}
+
+// loadPriceTable reads the price table of the workspace of file
+func loadPriceTable(file string) (*PriceTable, error) {
+	conf, err := util.GetConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	path := conf.PriceTable
+	if path == "" {
+		path = defaultPriceTable
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(conf.ContextDir, path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &PriceTable{}
+	if err := yaml.Unmarshal(b, t); err != nil {
+		return nil, fmt.Errorf("could not read price table %v: %v", path, err)
+	}
+	if t.HoursPerMonth == 0 {
+		t.HoursPerMonth = defaultHoursPerMonth
+	}
+	return t, nil
+}
+
+// estimateCost estimates the cost of the cluster's instance groups. The cost
+// before the changes in s is estimated from the instance groups with their
+// changes reverted; without s nothing is pending.
+func estimateCost(cluster *types.Cluster, s *State, prices *PriceTable) (*CostEstimate, error) {
+	e := &CostEstimate{Cluster: cluster.Name, Currency: prices.Currency, InstanceGroups: []InstanceGroupCost{}}
+	unknownMachineTypes, unknownVolumeTypes := map[string]bool{}, map[string]bool{}
+	for _, ig := range cluster.Kops.InstanceGroups {
+		cur := &kopsInstanceGroup{}
+		if err := decodeSpec(ig.Value, cur); err != nil {
+			return nil, fmt.Errorf("could not decode instance group %v: %v", ig.Name, err)
+		}
+		c := InstanceGroupCost{Name: ig.Name, MachineType: cur.Spec.MachineType}
+		c.MinSize, c.MaxSize = igSize(cur)
+		monthly, ok := prices.monthly(cur)
+		if !ok {
+			prices.missing(cur, unknownMachineTypes, unknownVolumeTypes)
+			c.Unknown = true
+		}
+		c.Monthly = monthly
+
+		prev := cur
+		if s != nil {
+			var err error
+			if prev, err = previousInstanceGroup(ig.Value, s.InstanceGroups[ig.Name]); err != nil {
+				return nil, fmt.Errorf("could not revert changes of instance group %v: %v", ig.Name, err)
+			}
+		}
+		var before CostRange
+		if prev != nil {
+			if before, ok = prices.monthly(prev); !ok {
+				prices.missing(prev, unknownMachineTypes, unknownVolumeTypes)
+				c.Unknown = true
+			}
+		}
+
+		if c.Unknown {
+			c.Monthly = CostRange{}
+		} else {
+			c.Delta = c.Monthly.sub(before)
+			e.Total = e.Total.add(c.Monthly)
+			e.Delta = e.Delta.add(c.Delta)
+		}
+		e.InstanceGroups = append(e.InstanceGroups, c)
+	}
+	e.UnknownMachineTypes = sortedKeys(unknownMachineTypes)
+	e.UnknownVolumeTypes = sortedKeys(unknownVolumeTypes)
+	return e, nil
+}
+
+// previousInstanceGroup returns the instance group as it was before the
+// changes in o, or nil if o adds it
+func previousInstanceGroup(value map[string]interface{}, o ObjectState) (*kopsInstanceGroup, error) {
+	if o.UpdateRequired && o.Version == "" {
+		return nil, nil
+	}
+	old, err := deepCopy(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range o.Changes {
+		obj, key := old, c.Path
+		if strings.HasPrefix(c.Path, "spec.") {
+			spec, _ := old["spec"].(map[string]interface{})
+			if spec == nil {
+				spec = map[string]interface{}{}
+				old["spec"] = spec
+			}
+			obj, key = spec, strings.TrimPrefix(c.Path, "spec.")
+		}
+		// Only whole fields matter for costs
+		if strings.ContainsAny(key, ".[") {
+			continue
+		}
+		if c.Op == specdiff.Added {
+			delete(obj, key)
+		} else {
+			obj[key] = c.Old
+		}
+	}
+	g := &kopsInstanceGroup{}
+	if err := decodeSpec(old, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// igSize returns the minimum and maximum size of an instance group
+func igSize(g *kopsInstanceGroup) (int32, int32) {
+	min := int32(1)
+	if g.Spec.MinSize != nil {
+		min = *g.Spec.MinSize
+	}
+	max := min
+	if g.Spec.MaxSize != nil {
+		max = *g.Spec.MaxSize
+	}
+	return min, max
+}
+
+// rootVolume returns the root volume size in GB and type of an instance group
+func rootVolume(g *kopsInstanceGroup) (int32, string) {
+	size := defaultRootVolumeSizes[g.Spec.Role]
+	if g.Spec.RootVolumeSize != nil {
+		size = *g.Spec.RootVolumeSize
+	}
+	volumeType := g.Spec.RootVolumeType
+	if volumeType == "" {
+		volumeType = defaultRootVolumeType
+	}
+	return size, volumeType
+}
+
+// monthly estimates the monthly cost of an instance group. It returns false if
+// the machine type or root volume type is not in the price table.
+func (t *PriceTable) monthly(g *kopsInstanceGroup) (CostRange, bool) {
+	hourly, ok := t.MachineTypes[g.Spec.MachineType]
+	if !ok {
+		return CostRange{}, false
+	}
+	size, volumeType := rootVolume(g)
+	volume, ok := t.Volumes[volumeType]
+	if !ok && size > 0 {
+		return CostRange{}, false
+	}
+	instance := hourly*t.HoursPerMonth + float64(size)*volume
+	min, max := igSize(g)
+	return CostRange{Min: float64(min) * instance, Max: float64(max) * instance}, true
+}
+
+// missing adds the machine type and root volume type of an instance group
+// that are not in the price table to machineTypes and volumeTypes
+func (t *PriceTable) missing(g *kopsInstanceGroup, machineTypes, volumeTypes map[string]bool) {
+	if _, ok := t.MachineTypes[g.Spec.MachineType]; !ok {
+		machineTypes[g.Spec.MachineType] = true
+	}
+	if size, volumeType := rootVolume(g); size > 0 {
+		if _, ok := t.Volumes[volumeType]; !ok {
+			volumeTypes[volumeType] = true
+		}
+	}
+}
+
+// printCostEstimate prints the cost of a cluster to out if the workspace has
+// a price table. Pending changes are taken from s.
+func printCostEstimate(out io.Writer, file string, cluster *types.Cluster, s *State) *CostEstimate {
+	prices, err := loadPriceTable(file)
+	if err != nil {
+		logrus.Debugf("Not estimating costs: %v", err)
+		return nil
+	}
+	e, err := estimateCost(cluster, s, prices)
+	if err != nil {
+		logrus.Warnf("Could not estimate costs: %v", err)
+		return nil
+	}
+	printCost(out, e)
+	return e
+}
+
+func printCost(out io.Writer, e *CostEstimate) {
+	fmt.Fprintf(out, "\nEstimated monthly cost of %v", e.Cluster)
+	if e.Currency != "" {
+		fmt.Fprintf(out, " (%v)", e.Currency)
+	}
+	fmt.Fprintln(out, ":")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE GROUP\tMACHINE TYPE\tSIZE\tMONTHLY\tDELTA")
+	for _, c := range e.InstanceGroups {
+		monthly, delta := "unknown", "unknown"
+		if !c.Unknown {
+			monthly, delta = formatCost(c.Monthly, false), formatCost(c.Delta, true)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v-%v\t%v\t%v\n", c.Name, c.MachineType, c.MinSize, c.MaxSize, monthly, delta)
+	}
+	fmt.Fprintf(w, "TOTAL\t\t\t%v\t%v\n", formatCost(e.Total, false), formatCost(e.Delta, true))
+	w.Flush()
+	if len(e.UnknownMachineTypes) > 0 {
+		fmt.Fprintf(out, "Machine types missing from the price table, left out of the total: %v\n", strings.Join(e.UnknownMachineTypes, ", "))
+	}
+	if len(e.UnknownVolumeTypes) > 0 {
+		fmt.Fprintf(out, "Volume types missing from the price table, left out of the total: %v\n", strings.Join(e.UnknownVolumeTypes, ", "))
+	}
+}
+
+func formatCost(r CostRange, signed bool) string {
+	f := "%.2f"
+	if signed {
+		f = "%+.2f"
+	}
+	if r.Min == r.Max {
+		return fmt.Sprintf(f, r.Min)
+	}
+	return fmt.Sprintf(f+".."+f, r.Min, r.Max)
+}
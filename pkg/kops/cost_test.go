@@ -0,0 +1,146 @@
+package kops
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/specdiff"
+	"github.com/wish/wk/pkg/types"
+)
+
+func costIG(name, machineType string, min, max int) types.InstanceGroup {
+	return types.InstanceGroup{Name: name, Value: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"role":           "Node",
+			"machineType":    machineType,
+			"minSize":        min,
+			"maxSize":        max,
+			"rootVolumeSize": 100,
+		},
+	}}
+}
+
+func TestEstimateCost(t *testing.T) {
+	prices := &PriceTable{
+		Currency:      "USD",
+		HoursPerMonth: 100,
+		MachineTypes:  map[string]float64{"m5.large": 0.1, "m5.xlarge": 0.2},
+		Volumes:       map[string]float64{"gp2": 0.1},
+	}
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{InstanceGroups: []types.InstanceGroup{
+		costIG("nodes", "m5.xlarge", 2, 4),
+		costIG("spot", "m5.large", 1, 1),
+		costIG("gpu", "p3.2xlarge", 1, 1),
+	}}}
+
+	s := newState()
+	s.InstanceGroups["nodes"] = ObjectState{UpdateRequired: true, Version: "a", Changes: []specdiff.Change{
+		{Path: "spec.machineType", Op: specdiff.Changed, Old: "m5.large", New: "m5.xlarge"},
+		{Path: "spec.maxSize", Op: specdiff.Changed, Old: 2.0, New: 4.0},
+	}}
+	s.InstanceGroups["spot"] = addedState(cluster.Kops.InstanceGroups[1].Value)
+	s.InstanceGroups["gpu"] = ObjectState{Version: "b"}
+
+	e, err := estimateCost(cluster, s, prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// m5.xlarge: 0.2*100 + 100*0.1 = 30 per instance; m5.large: 20 per instance
+	nodes := e.InstanceGroups[0]
+	if !closeTo(nodes.Monthly, CostRange{60, 120}) || !closeTo(nodes.Delta, CostRange{20, 80}) {
+		t.Errorf("unexpected nodes cost %+v", nodes)
+	}
+	spot := e.InstanceGroups[1]
+	if !closeTo(spot.Monthly, CostRange{20, 20}) || !closeTo(spot.Delta, CostRange{20, 20}) {
+		t.Errorf("unexpected spot cost %+v", spot)
+	}
+	if !e.InstanceGroups[2].Unknown {
+		t.Error("gpu should have an unknown machine type")
+	}
+	if !closeTo(e.Total, CostRange{80, 140}) || !closeTo(e.Delta, CostRange{40, 100}) {
+		t.Errorf("unexpected total %+v delta %+v", e.Total, e.Delta)
+	}
+	if !reflect.DeepEqual(e.UnknownMachineTypes, []string{"p3.2xlarge"}) {
+		t.Errorf("unexpected unknown machine types %v", e.UnknownMachineTypes)
+	}
+
+	out := &bytes.Buffer{}
+	printCost(out, e)
+	for _, want := range []string{"+20.00..+80.00", "p3.2xlarge", "unknown"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%q missing from:\n%v", want, out)
+		}
+	}
+}
+
+func TestEstimateCostUnknownVolumeType(t *testing.T) {
+	prices := &PriceTable{
+		HoursPerMonth: 100,
+		MachineTypes:  map[string]float64{"m5.large": 0.1},
+		Volumes:       map[string]float64{"gp2": 0.1},
+	}
+	io1 := costIG("io1", "m5.large", 1, 1)
+	io1.Value["spec"].(map[string]interface{})["rootVolumeType"] = "io1"
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{InstanceGroups: []types.InstanceGroup{
+		costIG("nodes", "m5.large", 1, 1),
+		io1,
+	}}}
+
+	e, err := estimateCost(cluster, nil, prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.InstanceGroups[1].Unknown {
+		t.Error("io1 should have an unknown volume type")
+	}
+	if !closeTo(e.Total, CostRange{20, 20}) {
+		t.Errorf("unexpected total %+v", e.Total)
+	}
+	if len(e.UnknownMachineTypes) != 0 || !reflect.DeepEqual(e.UnknownVolumeTypes, []string{"io1"}) {
+		t.Errorf("unexpected unknown machine types %v and volume types %v", e.UnknownMachineTypes, e.UnknownVolumeTypes)
+	}
+
+	out := &bytes.Buffer{}
+	printCost(out, e)
+	if !strings.Contains(out.String(), "Volume types missing from the price table, left out of the total: io1") {
+		t.Errorf("unknown volume type missing from:\n%v", out)
+	}
+}
+
+func TestLoadPriceTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-cost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, ".wk.yaml"), []byte("PriceTable: pricing/aws.yaml\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "cluster.jsonnet")
+	if _, err := loadPriceTable(file); !os.IsNotExist(err) {
+		t.Errorf("expected missing price table, got %v", err)
+	}
+
+	os.Mkdir(filepath.Join(dir, "pricing"), 0755)
+	if err := ioutil.WriteFile(filepath.Join(dir, "pricing", "aws.yaml"), []byte("Currency: USD\nMachineTypes:\n  m5.large: 0.096\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prices, err := loadPriceTable(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices.HoursPerMonth != defaultHoursPerMonth || prices.MachineTypes["m5.large"] != 0.096 {
+		t.Errorf("unexpected price table %+v", prices)
+	}
+}
+
+func closeTo(a, b CostRange) bool {
+	return math.Abs(a.Min-b.Min) < 1e-9 && math.Abs(a.Max-b.Max) < 1e-9
+}
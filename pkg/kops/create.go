@@ -0,0 +1,171 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/wish/wk/pkg/specdiff"
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// clusterExists checks whether the cluster is present in the kops state store
+func clusterExists(ctx context.Context, name string, env []string) (bool, error) {
+	return objectExists(ctx, env, "get", "cluster", "--name="+name)
+}
+
+// igExists checks whether the instance group is present in the kops state store
+func igExists(ctx context.Context, cluster, ig string, env []string) (bool, error) {
+	return objectExists(ctx, env, "get", "ig", "--name="+cluster, ig)
+}
+
+// objectExists runs a `kops get` command and tells whether the object was found
+func objectExists(ctx context.Context, env []string, args ...string) (bool, error) {
+	stderr := &bytes.Buffer{}
+	gCmd := kopsCommand(ctx, env, args...)
+	gCmd.Stderr = stderr
+	if err := gCmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "not found") {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not %v: %w: %v", strings.Join(args[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return true, nil
+}
+
+// createCluster bootstraps the cluster in the state store. If Kops.Create is set
+// it is passed to `kops create cluster` as flags, otherwise the full Cluster
+// spec is created with `kops create -f`. Instance groups are created later by
+// the regular edit flow.
+func createCluster(ctx context.Context, cluster *types.Cluster, env []string) error {
+	var spec []byte
+	if len(cluster.Kops.Create) > 0 {
+		logrus.Infoln("Creating cluster from create parameters.")
+		var err error
+		if spec, err = createParamsSpec(ctx, cluster, env); err != nil {
+			return err
+		}
+	} else {
+		logrus.Infoln("Creating cluster from cluster spec.")
+		var err error
+		if spec, err = json.Marshal(cluster.Kops.Cluster); err != nil {
+			return err
+		}
+	}
+	specFile, err := util.WriteTempFile(spec)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(specFile)
+	cCmd := kopsCommand(ctx, env, "create", "-f", specFile)
+	cCmd.Stdout, cCmd.Stderr = os.Stdout, os.Stderr
+	if err := cCmd.Run(); err != nil {
+		return fmt.Errorf("could not create cluster: %v", err)
+	}
+
+	// The dry run does not store the admin SSH key passed as a flag
+	if key, ok := cluster.Kops.Create["ssh-public-key"].(string); ok && key != "" {
+		if strings.HasPrefix(key, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			key = filepath.Join(home, key[2:])
+		}
+		sCmd := kopsCommand(ctx, env, "create", "secret", SecretSSHPublicKey, "admin", "-i", key, "--name="+cluster.Name)
+		sCmd.Stdout, sCmd.Stderr = os.Stdout, os.Stderr
+		if err := sCmd.Run(); err != nil {
+			return fmt.Errorf("could not create secret %v/admin: %v", SecretSSHPublicKey, err)
+		}
+	}
+	return nil
+}
+
+// createParamsSpec renders the Cluster spec kops would create from the create
+// parameters. `kops create cluster` also creates default instance groups, so
+// it is only run as a dry run and the instance groups of its output dropped;
+// the instance groups come from the cluster file.
+func createParamsSpec(ctx context.Context, cluster *types.Cluster, env []string) ([]byte, error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	dCmd := kopsCommand(ctx, env, append(createArgs(cluster.Name, cluster.Kops.Create), "--dry-run", "--output=yaml")...)
+	dCmd.Stdout, dCmd.Stderr = stdout, stderr
+	if err := dCmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not render cluster from create parameters: %v: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	docs := []string{}
+	for _, doc := range documentSeparator.Split(stdout.String(), -1) {
+		var meta struct {
+			Kind string `json:"kind"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &meta); err != nil {
+			return nil, fmt.Errorf("could not read cluster rendered from create parameters: %v", err)
+		}
+		if meta.Kind == "Cluster" {
+			docs = append(docs, strings.TrimSpace(doc))
+		}
+	}
+	if len(docs) != 1 {
+		return nil, fmt.Errorf("kops rendered %v clusters from create parameters, expected 1", len(docs))
+	}
+	return []byte(docs[0] + "\n"), nil
+}
+
+// createArgs converts create parameters to `kops create cluster` arguments.
+// Lists are joined with commas and true booleans become bare flags.
+func createArgs(name string, params map[string]interface{}) []string {
+	keys := []string{}
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := []string{"create", "cluster", "--name=" + name}
+	for _, k := range keys {
+		switch v := params[k].(type) {
+		case bool:
+			if v {
+				args = append(args, "--"+k)
+			} else {
+				args = append(args, "--"+k+"=false")
+			}
+		case []interface{}:
+			vals := []string{}
+			for _, e := range v {
+				vals = append(vals, fmt.Sprintf("%v", e))
+			}
+			args = append(args, "--"+k+"="+strings.Join(vals, ","))
+		case float64:
+			args = append(args, "--"+k+"="+strconv.FormatFloat(v, 'f', -1, 64))
+		case nil:
+		default:
+			args = append(args, fmt.Sprintf("--%v=%v", k, v))
+		}
+	}
+	return args
+}
+
+// previewCreate records the whole cluster and all instance groups as added
+func previewCreate(cluster *types.Cluster) *State {
+	s := newState()
+	s.Cluster = addedState(cluster.Kops.Cluster)
+	for _, ig := range cluster.Kops.InstanceGroups {
+		s.InstanceGroups[ig.Name] = addedState(ig.Value)
+	}
+	return s
+}
+
+// addedState is the state of an object missing from the state store
+func addedState(obj map[string]interface{}) ObjectState {
+	changes := specdiff.Compute(map[string]interface{}{}, obj)
+	return ObjectState{UpdateRequired: true, DiffText: specdiff.Render(changes), Changes: changes}
+}
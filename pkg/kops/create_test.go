@@ -0,0 +1,98 @@
+package kops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestCreateArgs(t *testing.T) {
+	params := map[string]interface{}{
+		"zones":               []interface{}{"us-west-2a", "us-west-2b"},
+		"node-count":          float64(3),
+		"bastion":             true,
+		"associate-public-ip": false,
+		"topology":            "private",
+		"unset":               nil,
+	}
+	want := []string{
+		"create", "cluster", "--name=test.k8s.local",
+		"--associate-public-ip=false",
+		"--bastion",
+		"--node-count=3",
+		"--topology=private",
+		"--zones=us-west-2a,us-west-2b",
+	}
+	if got := createArgs("test.k8s.local", params); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCreateClusterFromParams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	created := filepath.Join(dir, "created.yaml")
+	log, cleanup := stubKops(t, `
+case "$*" in
+*--dry-run*)
+	cat <<EOF
+apiVersion: kops.k8s.io/v1alpha2
+kind: Cluster
+metadata:
+  name: test.k8s.local
+spec:
+  kubernetesVersion: 1.15.0
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: master-us-west-2a
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: nodes
+EOF
+	;;
+"create -f "*)
+	cp "$3" `+created+`
+	;;
+esac`)
+	defer cleanup()
+
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{
+		Create: map[string]interface{}{"zones": "us-west-2a", "ssh-public-key": "/keys/id_rsa.pub"},
+	}}
+	if err := createCluster(context.Background(), cluster, nil); err != nil {
+		t.Fatal(err)
+	}
+	calls := readCalls(t, log)
+	want := []string{
+		"create cluster --name=test.k8s.local --ssh-public-key=/keys/id_rsa.pub --zones=us-west-2a --dry-run --output=yaml",
+		"create -f SPEC",
+		"create secret sshpublickey admin -i /keys/id_rsa.pub --name=test.k8s.local",
+	}
+	if len(calls) == len(want) && strings.HasPrefix(calls[1], "create -f ") {
+		calls[1] = "create -f SPEC"
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+
+	b, err := ioutil.ReadFile(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "InstanceGroup") || !strings.Contains(string(b), "kind: Cluster") {
+		t.Errorf("expected only the cluster to be created, got:\n%s", b)
+	}
+}
@@ -0,0 +1,108 @@
+package kops
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// ClusterDelete deletes the cluster defined in file. It shows the resources
+// kops would delete and requires the cluster name to be typed on in, or given
+// as confirm, before deleting anything. Clusters protected in the workspace
+// configuration are never deleted. The cluster is locked until kops returns;
+// the history and snapshots of wk are only deleted once kops deleted the
+// cluster.
+func ClusterDelete(ctx context.Context, file, confirm string, in io.Reader) error {
+	conf, err := util.GetConfig(file)
+	if err != nil {
+		return err
+	}
+
+	cluster, err := expandKopsCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	return deleteCluster(ctx, file, conf, cluster, confirm, in)
+}
+
+func deleteCluster(ctx context.Context, file string, conf *util.Config, cluster *types.Cluster, confirm string, in io.Reader) error {
+	if conf.IsProtected(cluster.Name) {
+		return fmt.Errorf("cluster %v is protected and cannot be deleted", cluster.Name)
+	}
+	kopsEnv, err := clusterEnv(ctx, file, cluster)
+	if err != nil {
+		return err
+	}
+	lockCtx, release, err := acquireLock(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logrus.Infof("Resources of cluster %v to be deleted:", cluster.Name)
+	pCmd := kopsCommand(lockCtx, kopsEnv, "delete", "cluster", "--name="+cluster.Name)
+	pCmd.Stdout, pCmd.Stderr = os.Stdout, os.Stderr
+	if err := pCmd.Run(); err != nil {
+		return fmt.Errorf("could not preview cluster deletion: %v", err)
+	}
+
+	if confirm == "" {
+		fmt.Printf("Type the cluster name to confirm deletion of %v: ", cluster.Name)
+		confirm, err = bufio.NewReader(in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if strings.TrimSpace(confirm) != cluster.Name {
+		return fmt.Errorf("confirmation does not match cluster name, not deleting")
+	}
+
+	if lockCtx.Err() != nil {
+		return lockCtx.Err()
+	}
+	// A first interrupt must not stop kops halfway through the deletion
+	logrus.Infof("Deleting cluster %v.", cluster.Name)
+	dCmd := kopsCommand(util.AbortContext(lockCtx), kopsEnv, "delete", "cluster", "--name="+cluster.Name, "--yes")
+	dCmd.Stdout, dCmd.Stderr = os.Stdout, os.Stderr
+	if err := dCmd.Run(); err != nil {
+		return fmt.Errorf("could not delete cluster: %v", err)
+	}
+	if err := removeWkFiles(cluster); err != nil {
+		return fmt.Errorf("cluster %v was deleted, but %v", cluster.Name, err)
+	}
+	return nil
+}
+
+// removeWkFiles removes the files of wk for the cluster from the state store,
+// except for its lock
+func removeWkFiles(cluster *types.Cluster) error {
+	p, err := stateStorePath(cluster)
+	if err != nil {
+		return err
+	}
+	lock, err := lockPath(cluster)
+	if err != nil {
+		return err
+	}
+	files, err := p.ReadTree()
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not list files of wk in the state store: %v", err)
+	}
+	for _, f := range files {
+		if f.Path() == lock.Path() {
+			continue
+		}
+		if err := f.Remove(); err != nil {
+			return fmt.Errorf("could not remove %v: %v", f.Path(), err)
+		}
+	}
+	return nil
+}
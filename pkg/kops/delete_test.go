@@ -0,0 +1,87 @@
+package kops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+func TestDeleteCluster(t *testing.T) {
+	store, err := ioutil.TempDir("", "wk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+	lockSettle = 0
+	wk := filepath.Join(store, wkDir, "test.k8s.local")
+	lock := filepath.Join(wk, "lock.json")
+
+	// The stub notes whether the cluster is locked while kops runs. Deleting
+	// fails while $fail is set.
+	log, cleanup := stubKops(t, "[ -e "+lock+" ] && echo locked >> $(dirname $0)/calls; case \"$*\" in *--yes) [ -z \"$fail\" ];; esac")
+	defer cleanup()
+
+	conf := &util.Config{ProtectedClusters: []string{"prod.k8s.local"}}
+	cluster := func(name string) *types.Cluster {
+		return &types.Cluster{Name: name, Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "file://" + store}}}
+	}
+
+	err = deleteCluster(context.Background(), "", conf, cluster("prod.k8s.local"), "prod.k8s.local", nil)
+	if err == nil || !strings.Contains(err.Error(), "protected") {
+		t.Errorf("expected protected cluster to be refused, got %v", err)
+	}
+	if _, err := os.Stat(log); !os.IsNotExist(err) {
+		t.Errorf("expected kops not to run for a protected cluster")
+	}
+
+	err = deleteCluster(context.Background(), "", conf, cluster("test.k8s.local"), "", strings.NewReader("other.k8s.local\n"))
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected mismatching confirmation to be refused, got %v", err)
+	}
+	want := []string{"delete cluster --name=test.k8s.local", "locked"}
+	if calls := readCalls(t, log); !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("expected lock to be released: %v", err)
+	}
+
+	history := filepath.Join(wk, "history", "1.json")
+	os.MkdirAll(filepath.Dir(history), 0755)
+	if err := ioutil.WriteFile(history, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed deletion keeps the history
+	os.Setenv("fail", "1")
+	err = deleteCluster(context.Background(), "", conf, cluster("test.k8s.local"), "test.k8s.local", nil)
+	os.Unsetenv("fail")
+	if err == nil || !strings.Contains(err.Error(), "could not delete cluster") {
+		t.Errorf("expected failed deletion, got %v", err)
+	}
+	if _, err := os.Stat(history); err != nil {
+		t.Errorf("expected history to be kept: %v", err)
+	}
+
+	os.Remove(log)
+	if err := deleteCluster(context.Background(), "", conf, cluster("test.k8s.local"), "", strings.NewReader("test.k8s.local\n")); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"delete cluster --name=test.k8s.local", "locked", "delete cluster --name=test.k8s.local --yes", "locked"}
+	if calls := readCalls(t, log); !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+	if _, err := os.Stat(history); !os.IsNotExist(err) {
+		t.Errorf("expected history to be removed with the cluster: %v", err)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("expected lock to be released: %v", err)
+	}
+}
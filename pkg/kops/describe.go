@@ -0,0 +1,306 @@
+package kops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/wish/wk/pkg/jsonnet"
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// defaultMachineTypes is the machine type table file in the workspace used
+// unless the workspace configures another
+const defaultMachineTypes = "machine-types.yaml"
+
+// MachineType holds the resources of a machine type
+type MachineType struct {
+	VCPU float64
+	// Memory is in GiB
+	Memory float64
+}
+
+// SubnetDescription describes a subnet of a cluster
+type SubnetDescription struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	CIDR string `json:"cidr"`
+	Zone string `json:"zone"`
+}
+
+// InstanceGroupDescription describes an instance group of a cluster
+type InstanceGroupDescription struct {
+	Name        string            `json:"name"`
+	Role        string            `json:"role"`
+	MachineType string            `json:"machineType"`
+	MinSize     int32             `json:"minSize"`
+	MaxSize     int32             `json:"maxSize"`
+	Zones       []string          `json:"zones"`
+	NodeLabels  map[string]string `json:"nodeLabels,omitempty"`
+	Taints      []string          `json:"taints,omitempty"`
+}
+
+// Capacity is the total vCPUs and memory in GiB of instance groups at their
+// minimum and maximum size
+type Capacity struct {
+	MinVCPU   float64 `json:"minVCPU"`
+	MaxVCPU   float64 `json:"maxVCPU"`
+	MinMemory float64 `json:"minMemory"`
+	MaxMemory float64 `json:"maxMemory"`
+	// UnknownMachineTypes are the machine types missing from the machine
+	// type table, left out of the totals
+	UnknownMachineTypes []string `json:"unknownMachineTypes"`
+}
+
+// ChannelDescription describes a channel of a cluster
+type ChannelDescription struct {
+	Name string `json:"name"`
+	Apps int    `json:"apps"`
+}
+
+// ClusterDescription summarizes a rendered cluster file
+type ClusterDescription struct {
+	Cluster           string                     `json:"cluster"`
+	KubernetesVersion string                     `json:"kubernetesVersion"`
+	Networking        string                     `json:"networking"`
+	Zones             []string                   `json:"zones"`
+	Subnets           []SubnetDescription        `json:"subnets"`
+	InstanceGroups    []InstanceGroupDescription `json:"instanceGroups"`
+	// Capacity is nil if the workspace has no machine type table
+	Capacity *Capacity            `json:"capacity,omitempty"`
+	Channels []ChannelDescription `json:"channels"`
+}
+
+// ClusterDescribe renders the cluster file and prints a summary of the
+// cluster, its instance groups, capacity and channels
+func ClusterDescribe(ctx context.Context, file string, out io.Writer, asJSON bool) error {
+	conf, err := util.GetConfig(file)
+	if err != nil {
+		return err
+	}
+	cluster, _, err := jsonnet.ExpandCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	if cluster.Kops == nil {
+		return fmt.Errorf("kops configuration is missing")
+	}
+	machineTypes, err := loadMachineTypes(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	d, err := describeCluster(cluster, machineTypes)
+	if err != nil {
+		return err
+	}
+	for _, channel := range cluster.Kops.Channels {
+		apps := len(channel.Apps)
+		if channel.Folder != "" {
+			files, err := channelFiles(conf.ContextDir, channel)
+			if err != nil {
+				return fmt.Errorf("could not list apps of channel %v: %v", channel.Name, err)
+			}
+			apps += len(files)
+		}
+		d.Channels = append(d.Channels, ChannelDescription{Name: channel.Name, Apps: apps})
+	}
+
+	if asJSON {
+		b, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(b))
+		return err
+	}
+	printDescription(out, d)
+	return nil
+}
+
+// loadMachineTypes reads the machine type table of the workspace of file
+func loadMachineTypes(file string) (map[string]MachineType, error) {
+	conf, err := util.GetConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	path := conf.MachineTypes
+	if path == "" {
+		path = defaultMachineTypes
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(conf.ContextDir, path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := map[string]MachineType{}
+	if err := yaml.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("could not read machine type table %v: %v", path, err)
+	}
+	return t, nil
+}
+
+// describeCluster summarizes the cluster spec and instance groups. Capacity
+// is only summed up if machineTypes is set.
+func describeCluster(cluster *types.Cluster, machineTypes map[string]MachineType) (*ClusterDescription, error) {
+	c := &kopsCluster{}
+	if err := decodeSpec(cluster.Kops.Cluster, c); err != nil {
+		return nil, fmt.Errorf("could not decode cluster: %v", err)
+	}
+	d := &ClusterDescription{
+		Cluster:           cluster.Name,
+		KubernetesVersion: c.Spec.KubernetesVersion,
+		Zones:             []string{},
+		Subnets:           []SubnetDescription{},
+		InstanceGroups:    []InstanceGroupDescription{},
+		Channels:          []ChannelDescription{},
+	}
+	networking := []string{}
+	for n := range c.Spec.Networking {
+		networking = append(networking, n)
+	}
+	sort.Strings(networking)
+	d.Networking = strings.Join(networking, ", ")
+
+	subnetZones := map[string]string{}
+	zones := map[string]bool{}
+	for _, s := range c.Spec.Subnets {
+		d.Subnets = append(d.Subnets, SubnetDescription{Name: s.Name, Type: s.Type, CIDR: s.CIDR, Zone: s.Zone})
+		subnetZones[s.Name] = s.Zone
+		zones[s.Zone] = true
+	}
+	d.Zones = sortedKeys(zones)
+
+	if machineTypes != nil {
+		d.Capacity = &Capacity{UnknownMachineTypes: []string{}}
+	}
+	unknown := map[string]bool{}
+	for _, ig := range cluster.Kops.InstanceGroups {
+		g := &kopsInstanceGroup{}
+		if err := decodeSpec(ig.Value, g); err != nil {
+			return nil, fmt.Errorf("could not decode instance group %v: %v", ig.Name, err)
+		}
+		i := InstanceGroupDescription{
+			Name:        ig.Name,
+			Role:        g.Spec.Role,
+			MachineType: g.Spec.MachineType,
+			NodeLabels:  g.Spec.NodeLabels,
+			Taints:      g.Spec.Taints,
+		}
+		i.MinSize, i.MaxSize = igSize(g)
+		igZones := map[string]bool{}
+		for _, s := range g.Spec.Subnets {
+			if z := subnetZones[s]; z != "" {
+				igZones[z] = true
+			}
+		}
+		for _, z := range g.Spec.Zones {
+			igZones[z] = true
+		}
+		i.Zones = sortedKeys(igZones)
+		d.InstanceGroups = append(d.InstanceGroups, i)
+
+		if d.Capacity == nil {
+			continue
+		}
+		m, ok := machineTypes[g.Spec.MachineType]
+		if !ok {
+			unknown[g.Spec.MachineType] = true
+			continue
+		}
+		d.Capacity.MinVCPU += float64(i.MinSize) * m.VCPU
+		d.Capacity.MaxVCPU += float64(i.MaxSize) * m.VCPU
+		d.Capacity.MinMemory += float64(i.MinSize) * m.Memory
+		d.Capacity.MaxMemory += float64(i.MaxSize) * m.Memory
+	}
+	if d.Capacity != nil {
+		d.Capacity.UnknownMachineTypes = sortedKeys(unknown)
+	}
+	return d, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	out := []string{}
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func printDescription(out io.Writer, d *ClusterDescription) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Cluster:\t%v\n", d.Cluster)
+	fmt.Fprintf(w, "Kubernetes version:\t%v\n", d.KubernetesVersion)
+	fmt.Fprintf(w, "Networking:\t%v\n", d.Networking)
+	fmt.Fprintf(w, "Zones:\t%v\n", strings.Join(d.Zones, ", "))
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBNET\tTYPE\tCIDR\tZONE")
+	for _, s := range d.Subnets {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", s.Name, s.Type, s.CIDR, s.Zone)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE GROUP\tROLE\tMACHINE TYPE\tSIZE\tZONES\tNODE LABELS\tTAINTS")
+	for _, i := range d.InstanceGroups {
+		labels := []string{}
+		for k, v := range i.NodeLabels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v-%v\t%v\t%v\t%v\n", i.Name, i.Role, i.MachineType, i.MinSize, i.MaxSize,
+			orNone(strings.Join(i.Zones, ",")), orNone(strings.Join(labels, ",")), orNone(strings.Join(i.Taints, ",")))
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	if c := d.Capacity; c != nil {
+		fmt.Fprintf(out, "Capacity: %v vCPU, %v GiB memory\n", formatAmount(c.MinVCPU, c.MaxVCPU), formatAmount(c.MinMemory, c.MaxMemory))
+		if len(c.UnknownMachineTypes) > 0 {
+			fmt.Fprintf(out, "Machine types missing from the machine type table, left out of the capacity: %v\n", strings.Join(c.UnknownMachineTypes, ", "))
+		}
+	} else {
+		fmt.Fprintln(out, "Capacity: unknown, the workspace has no machine type table")
+	}
+
+	if len(d.Channels) > 0 {
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CHANNEL\tAPPS")
+		for _, c := range d.Channels {
+			fmt.Fprintf(w, "%v\t%v\n", c.Name, c.Apps)
+		}
+		w.Flush()
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+func formatAmount(min, max float64) string {
+	if min == max {
+		return fmt.Sprintf("%g", min)
+	}
+	return fmt.Sprintf("%g..%g", min, max)
+}
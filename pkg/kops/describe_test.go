@@ -0,0 +1,62 @@
+package kops
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestDescribeCluster(t *testing.T) {
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{
+		Cluster: map[string]interface{}{"spec": map[string]interface{}{
+			"kubernetesVersion": "1.15.3",
+			"networking":        map[string]interface{}{"calico": map[string]interface{}{}},
+			"subnets": []interface{}{
+				map[string]interface{}{"name": "us-east-1a", "type": "Private", "cidr": "10.0.0.0/20", "zone": "us-east-1a"},
+				map[string]interface{}{"name": "us-east-1b", "type": "Private", "cidr": "10.0.16.0/20", "zone": "us-east-1b"},
+			},
+		}},
+		InstanceGroups: []types.InstanceGroup{
+			{Name: "nodes", Value: map[string]interface{}{"spec": map[string]interface{}{
+				"role": "Node", "machineType": "m5.xlarge", "minSize": 2, "maxSize": 4,
+				"subnets":    []interface{}{"us-east-1a", "us-east-1b"},
+				"nodeLabels": map[string]interface{}{"pool": "general"},
+			}}},
+			{Name: "gpu", Value: map[string]interface{}{"spec": map[string]interface{}{
+				"role": "Node", "machineType": "p3.2xlarge", "minSize": 0, "maxSize": 1,
+				"subnets": []interface{}{"us-east-1a"},
+				"taints":  []interface{}{"nvidia.com/gpu=present:NoSchedule"},
+			}}},
+		},
+	}}
+
+	d, err := describeCluster(cluster, map[string]MachineType{"m5.xlarge": {VCPU: 4, Memory: 16}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.KubernetesVersion != "1.15.3" || d.Networking != "calico" || !reflect.DeepEqual(d.Zones, []string{"us-east-1a", "us-east-1b"}) {
+		t.Errorf("unexpected description %+v", d)
+	}
+	nodes := d.InstanceGroups[0]
+	if nodes.MinSize != 2 || nodes.MaxSize != 4 || len(nodes.Zones) != 2 || nodes.NodeLabels["pool"] != "general" {
+		t.Errorf("unexpected nodes %+v", nodes)
+	}
+	if c := d.Capacity; c.MinVCPU != 8 || c.MaxVCPU != 16 || c.MaxMemory != 64 || !reflect.DeepEqual(c.UnknownMachineTypes, []string{"p3.2xlarge"}) {
+		t.Errorf("unexpected capacity %+v", c)
+	}
+
+	out := &bytes.Buffer{}
+	printDescription(out, d)
+	for _, want := range []string{"8..16 vCPU", "pool=general", "nvidia.com/gpu=present:NoSchedule", "p3.2xlarge"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%q missing from:\n%v", want, out)
+		}
+	}
+
+	if d, err := describeCluster(cluster, nil); err != nil || d.Capacity != nil {
+		t.Errorf("expected no capacity without a machine type table, got %+v, %v", d, err)
+	}
+}
@@ -0,0 +1,73 @@
+package kops
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/wish/wk/pkg/specdiff"
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// defaultPreserve are server-managed fields always kept from the state store.
+// Otherwise they always show a diff.
+var defaultPreserve = []string{"metadata.creationTimestamp", "metadata.generation"}
+
+// diffRules combines the workspace and the cluster diff rules
+func diffRules(file string, cluster *types.Cluster) (types.DiffRules, error) {
+	conf, err := util.GetConfig(file)
+	if err != nil {
+		return types.DiffRules{}, err
+	}
+	defaults := types.DiffRules{
+		Cluster:        types.DiffPaths{Preserve: defaultPreserve},
+		InstanceGroups: types.DiffPaths{Preserve: defaultPreserve},
+	}
+	return defaults.Merge(conf.Diff).Merge(cluster.Kops.Diff), nil
+}
+
+// applyDiffRules copies preserved paths from old into new, which is modified
+// in place, and returns copies of old and new with the ignored paths removed
+// for diffing.
+func applyDiffRules(old, new map[string]interface{}, paths types.DiffPaths) (map[string]interface{}, map[string]interface{}, error) {
+	preserve, err := specdiff.ParsePatterns(paths.Preserve)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid preserve path: %v", err)
+	}
+	ignore, err := specdiff.ParsePatterns(paths.Ignore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ignore path: %v", err)
+	}
+	for _, p := range preserve {
+		specdiff.Preserve(old, new, p)
+	}
+	if len(ignore) == 0 {
+		return old, new, nil
+	}
+
+	oldC, err := deepCopy(old)
+	if err != nil {
+		return nil, nil, err
+	}
+	newC, err := deepCopy(new)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range ignore {
+		specdiff.Strip(oldC, p)
+		specdiff.Strip(newC, p)
+	}
+	return oldC, newC, nil
+}
+
+func deepCopy(m map[string]interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
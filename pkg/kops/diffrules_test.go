@@ -0,0 +1,38 @@
+package kops
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestApplyDiffRules(t *testing.T) {
+	old := map[string]interface{}{
+		"metadata": map[string]interface{}{"creationTimestamp": "2019-01-01"},
+		"spec":     map[string]interface{}{"image": "a", "maxSize": 3.0},
+	}
+	new := map[string]interface{}{
+		"spec": map[string]interface{}{"image": "b", "maxSize": 3.0},
+	}
+	paths := types.DiffPaths{Preserve: defaultPreserve, Ignore: []string{"spec.image"}}
+	oldDiff, newDiff, err := applyDiffRules(old, new, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(oldDiff, newDiff) {
+		t.Errorf("expected no diff, got %v and %v", oldDiff, newDiff)
+	}
+	// The rendered object keeps ignored fields and gains preserved ones
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{"creationTimestamp": "2019-01-01"},
+		"spec":     map[string]interface{}{"image": "b", "maxSize": 3.0},
+	}
+	if !reflect.DeepEqual(new, want) {
+		t.Errorf("got %v, want %v", new, want)
+	}
+
+	if _, _, err := applyDiffRules(old, new, types.DiffPaths{Ignore: []string{"spec..image"}}); err == nil {
+		t.Errorf("expected error for invalid path")
+	}
+}
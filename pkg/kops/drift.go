@@ -0,0 +1,295 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/wk/pkg/jsonnet"
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// DriftExitCode is the exit code used when drift was found
+const DriftExitCode = 3
+
+// ClusterDrift describes how a cluster in the state store differs from the workspace
+type ClusterDrift struct {
+	File    string
+	Cluster string
+	// Missing is set when the cluster is not in the state store
+	Missing bool `json:",omitempty"`
+	// ClusterDiff is the diff of the cluster spec, empty if it matches
+	ClusterDiff string `json:",omitempty"`
+	// InstanceGroups maps drifting instance groups to their diffs
+	InstanceGroups map[string]string `json:",omitempty"`
+	// Channels maps drifting channels to their differing files
+	Channels map[string][]string `json:",omitempty"`
+	Error    string              `json:",omitempty"`
+}
+
+// Drifted reports whether any drift was found
+func (d *ClusterDrift) Drifted() bool {
+	return d.Missing || d.ClusterDiff != "" || len(d.InstanceGroups) > 0 || len(d.Channels) > 0
+}
+
+// DriftError is returned when drift was found in any cluster
+type DriftError struct {
+	Clusters int
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("drift found in %v clusters", e.Clusters)
+}
+
+// Drift compares the rendered cluster files with the kops state store and the
+// published channels. It returns a DriftError if anything differs.
+func Drift(ctx context.Context, files []string, out io.Writer, asJSON bool) error {
+	report := []*ClusterDrift{}
+	drifted, failed := 0, 0
+	for _, file := range files {
+		d := clusterDrift(ctx, file)
+		if d.Error != "" {
+			failed++
+		} else if d.Drifted() {
+			drifted++
+		}
+		report = append(report, d)
+	}
+
+	if asJSON {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(b))
+	} else {
+		printDrift(out, report)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("drift detection failed for %v clusters", failed)
+	}
+	if drifted > 0 {
+		return &DriftError{Clusters: drifted}
+	}
+	return nil
+}
+
+func clusterDrift(ctx context.Context, file string) *ClusterDrift {
+	d := &ClusterDrift{File: file}
+	if err := func() error {
+		dir, err := ioutil.TempDir("", "wk-drift")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		conf, err := util.GetConfig(file)
+		if err != nil {
+			return err
+		}
+		cluster, tfile, err := jsonnet.ExpandCluster(ctx, file)
+		if err != nil {
+			return err
+		}
+		if cluster.Kops == nil {
+			return fmt.Errorf("kops configuration is missing")
+		}
+		d.Cluster = cluster.Name
+		kopsEnv, err := clusterEnv(ctx, file, cluster)
+		if err != nil {
+			return err
+		}
+
+		exists, err := clusterExists(ctx, cluster.Name, kopsEnv)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			d.Missing = true
+			return nil
+		}
+		s, err := runEdits(ctx, cluster, file, tfile, "preview", kopsEnv, ClusterApplyOptions{})
+		if err != nil {
+			return err
+		}
+		d.ClusterDiff = s.Cluster.DiffText
+		for name, ig := range s.InstanceGroups {
+			if ig.UpdateRequired {
+				if d.InstanceGroups == nil {
+					d.InstanceGroups = map[string]string{}
+				}
+				d.InstanceGroups[name] = ig.DiffText
+			}
+		}
+
+		d.Channels, err = channelsDrift(ctx, file, conf.ContextDir, cluster, dir)
+		if err != nil {
+			return err
+		}
+		return nil
+	}(); err != nil {
+		logrus.Errorf("Could not detect drift of %v: %v", file, err)
+		d.Error = err.Error()
+	}
+	return d
+}
+
+// channelsDrift renders each published channel of the cluster into its own
+// directory in dir, as it is published, and compares it with the published one.
+// Only channels with differing files are returned.
+func channelsDrift(ctx context.Context, file, ctxDir string, cluster *types.Cluster, dir string) (map[string][]string, error) {
+	var drift map[string][]string
+	for i, channel := range cluster.Kops.Channels {
+		if channel.Path == "" {
+			continue
+		}
+		chDir := filepath.Join(dir, strconv.Itoa(i))
+		if _, err := renderChannelApps(ctx, file, ctxDir, []types.Channel{channel}, chDir, nil); err != nil {
+			return nil, fmt.Errorf("could not render channel %v: %v", channel.Name, err)
+		}
+		files, err := channelDrift(cluster, channel, chDir)
+		if err != nil {
+			return nil, fmt.Errorf("could not compare channel %v: %v", channel.Name, err)
+		}
+		if len(files) > 0 {
+			if drift == nil {
+				drift = map[string][]string{}
+			}
+			drift[channel.Name] = files
+		}
+	}
+	return drift, nil
+}
+
+// channelDrift compares the channel rendered into dir with the published one
+// and returns the differing files prefixed with '+' (only rendered), '-' (only
+// published) or '~' (contents differ).
+func channelDrift(cluster *types.Cluster, channel types.Channel, dir string) ([]string, error) {
+	if channel.Path == "" {
+		return nil, nil
+	}
+	remote, err := storePath(cluster, channel.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	local := map[string][]byte{}
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		local[strings.TrimPrefix(path, dir+string(filepath.Separator))] = b
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	published, err := remote.ReadTree()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	for _, p := range published {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p.Path(), remote.Path()), "/")
+		seen[rel] = true
+		want, ok := local[rel]
+		if !ok {
+			files = append(files, "- "+rel)
+			continue
+		}
+		got, err := p.ReadFile()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(got, want) {
+			files = append(files, "~ "+rel)
+		}
+	}
+	for rel := range local {
+		if !seen[rel] {
+			files = append(files, "+ "+rel)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i][2:] < files[j][2:] })
+	return files, nil
+}
+
+func printDrift(out io.Writer, report []*ClusterDrift) {
+	for _, d := range report {
+		if !d.Drifted() || d.Error != "" {
+			continue
+		}
+		fmt.Fprintf(out, "Cluster %v (%v) drifted:\n", d.Cluster, d.File)
+		if d.Missing {
+			fmt.Fprintf(out, "  cluster missing from state store\n")
+		}
+		if d.ClusterDiff != "" {
+			fmt.Fprintf(out, "  cluster spec:\n%v\n", indent(d.ClusterDiff, "    "))
+		}
+		names := []string{}
+		for name := range d.InstanceGroups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "  instance group %v:\n%v\n", name, indent(d.InstanceGroups[name], "    "))
+		}
+		channels := []string{}
+		for name := range d.Channels {
+			channels = append(channels, name)
+		}
+		sort.Strings(channels)
+		for _, name := range channels {
+			fmt.Fprintf(out, "  channel %v:\n%v\n", name, indent(strings.Join(d.Channels[name], "\n"), "    "))
+		}
+		fmt.Fprintln(out)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tFILE\tSTATUS\tINSTANCE GROUPS\tCHANNELS")
+	for _, d := range report {
+		status := "in sync"
+		switch {
+		case d.Error != "":
+			status = "error"
+		case d.Missing:
+			status = "missing"
+		case d.Drifted():
+			status = "drifted"
+		}
+		igs := []string{}
+		for name := range d.InstanceGroups {
+			igs = append(igs, name)
+		}
+		sort.Strings(igs)
+		channels := []string{}
+		for name := range d.Channels {
+			channels = append(channels, name)
+		}
+		sort.Strings(channels)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", d.Cluster, d.File, status, strings.Join(igs, ","), strings.Join(channels, ","))
+	}
+	w.Flush()
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,109 @@
+package kops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestChannelDrift(t *testing.T) {
+	local, err := ioutil.TempDir("", "wk-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+	remote, err := ioutil.TempDir("", "wk-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+
+	write := func(dir, name, data string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(local, "channel.yaml", "a")
+	write(local, "apps/dns.json", "dns")
+	write(local, "apps/new.json", "new")
+	write(remote, "channel.yaml", "b")
+	write(remote, "apps/dns.json", "dns")
+	write(remote, "apps/old.json", "old")
+
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{}}
+	files, err := channelDrift(cluster, types.Channel{Name: "wish", Path: "file://" + remote}, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"+ apps/new.json", "- apps/old.json", "~ channel.yaml"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got %q, want %q", files, want)
+	}
+}
+
+func TestChannelsDrift(t *testing.T) {
+	// The stub renders app files as they are
+	_, cleanup := stubCommand(t, "jsonnet", `while [ $# -gt 1 ]; do [ "$1" = -o ] && out=$2; shift; done; cp "$1" "$out"`)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "wk-channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".wk.yaml", "")
+	write("cluster.jsonnet", "{}")
+	write("channels/a/dns.jsonnet", `{"kind": "dns"}`)
+	write("channels/b/web.jsonnet", `{"kind": "web"}`)
+	file := filepath.Join(dir, "cluster.jsonnet")
+
+	channels := []types.Channel{
+		{Name: "a", Folder: "channels/a", Path: "file://" + filepath.Join(dir, "published/a")},
+		{Name: "b", Folder: "channels/b", Path: "file://" + filepath.Join(dir, "published/b")},
+	}
+	for _, channel := range channels {
+		if _, err := renderChannelApps(context.Background(), file, dir, []types.Channel{channel}, filepath.Join(dir, "published", channel.Name), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{Channels: channels}}
+
+	render := func() map[string][]string {
+		out, err := ioutil.TempDir("", "wk-drift")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(out)
+		drift, err := channelsDrift(context.Background(), file, dir, cluster, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return drift
+	}
+	if drift := render(); drift != nil {
+		t.Errorf("expected published channels to be in sync, got %q", drift)
+	}
+
+	write("published/b/web.json", `{"kind": "old"}`)
+	if drift, want := render(), map[string][]string{"b": {"~ web.json"}}; !reflect.DeepEqual(drift, want) {
+		t.Errorf("got %q, want %q", drift, want)
+	}
+}
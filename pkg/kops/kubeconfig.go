@@ -0,0 +1,256 @@
+package kops
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/wish/wk/pkg/util"
+)
+
+// kubeconfigExtension names the context extension marking contexts written by wk
+const kubeconfigExtension = "wk"
+
+// KubeconfigOptions configures Kubeconfig
+type KubeconfigOptions struct {
+	// Path is the kubeconfig to merge into, by default $KUBECONFIG or ~/.kube/config
+	Path string
+	// Prune removes contexts written by wk for clusters no longer in the workspace inventory
+	Prune bool
+}
+
+// kubeconfig is a kubeconfig file decoded generically, so fields wk does not
+// know about are kept as they are
+type kubeconfig map[string]interface{}
+
+// Kubeconfig exports the kubeconfig of each cluster with the cluster's kops
+// environment and merges it into the target kubeconfig. The cluster, user and
+// context entries are all named after the cluster, prefixed with the
+// workspace's KubeContextPrefix.
+func Kubeconfig(ctx context.Context, files []string, opts KubeconfigOptions) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no clusters given")
+	}
+	conf, err := util.GetConfig(files[0])
+	if err != nil {
+		return err
+	}
+
+	path := opts.Path
+	if path == "" {
+		path = defaultKubeconfigPath()
+	}
+	target, err := readKubeconfig(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		cluster, err := expandKopsCluster(ctx, file)
+		if err != nil {
+			return err
+		}
+		kopsEnv, err := clusterEnv(ctx, file, cluster)
+		if err != nil {
+			return err
+		}
+		exported, err := exportKubeconfig(ctx, cluster.Name, kopsEnv)
+		if err != nil {
+			return err
+		}
+		name := conf.KubeContextPrefix + cluster.Name
+		if err := target.merge(exported, cluster.Name, name, conf.ContextDir); err != nil {
+			return fmt.Errorf("could not merge kubeconfig of %v: %v", cluster.Name, err)
+		}
+		logrus.Infof("Exported context %v.", name)
+	}
+
+	if opts.Prune {
+		keep := map[string]bool{}
+		for _, e := range conf.Clusters {
+			cluster, err := expandKopsCluster(ctx, conf.Path(e))
+			if err != nil {
+				return err
+			}
+			keep[conf.KubeContextPrefix+cluster.Name] = true
+		}
+		for _, name := range target.prune(conf.ContextDir, keep) {
+			logrus.Infof("Pruned context %v.", name)
+		}
+	}
+
+	return writeKubeconfig(path, target)
+}
+
+func defaultKubeconfigPath() string {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return filepath.SplitList(env)[0]
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.Getenv("HOME")
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// exportKubeconfig runs `kops export kubecfg` into a temporary kubeconfig
+func exportKubeconfig(ctx context.Context, name string, env []string) (kubeconfig, error) {
+	dir, err := ioutil.TempDir("", "wk-kubeconfig")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+
+	eCmd := kopsCommand(ctx, append(env, "KUBECONFIG="+path), "export", "kubecfg", "--name="+name)
+	eCmd.Stdout, eCmd.Stderr = os.Stderr, os.Stderr
+	if err := eCmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not export kubeconfig of %v: %v", name, err)
+	}
+	return readKubeconfig(path)
+}
+
+func readKubeconfig(path string) (kubeconfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return kubeconfig{"apiVersion": "v1", "kind": "Config"}, nil
+		}
+		return nil, err
+	}
+	k := kubeconfig{}
+	if err := yaml.Unmarshal(b, &k); err != nil {
+		return nil, fmt.Errorf("could not read kubeconfig %v: %v", path, err)
+	}
+	return k, nil
+}
+
+func writeKubeconfig(path string, k kubeconfig) error {
+	b, err := yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// list returns the named entries under key, e.g. "clusters"
+func (k kubeconfig) list(key string) []interface{} {
+	l, _ := k[key].([]interface{})
+	return l
+}
+
+// find returns the entry named name under key
+func (k kubeconfig) find(key, name string) map[string]interface{} {
+	for _, e := range k.list(key) {
+		if m, ok := e.(map[string]interface{}); ok && m["name"] == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// set replaces or appends the entry under key with the entry's name
+func (k kubeconfig) set(key string, entry map[string]interface{}) {
+	l := k.list(key)
+	for i, e := range l {
+		if m, ok := e.(map[string]interface{}); ok && m["name"] == entry["name"] {
+			l[i] = entry
+			return
+		}
+	}
+	k[key] = append(l, entry)
+}
+
+// remove drops the entry named name under key
+func (k kubeconfig) remove(key, name string) {
+	out := []interface{}{}
+	for _, e := range k.list(key) {
+		if m, ok := e.(map[string]interface{}); !ok || m["name"] != name {
+			out = append(out, e)
+		}
+	}
+	k[key] = out
+}
+
+// merge copies the context named from in exported into k as name, together
+// with its cluster and user, and marks it as written by wk for workspace.
+func (k kubeconfig) merge(exported kubeconfig, from, name, workspace string) error {
+	ctx := exported.find("contexts", from)
+	if ctx == nil {
+		return fmt.Errorf("context %v not found in exported kubeconfig", from)
+	}
+	c, _ := ctx["context"].(map[string]interface{})
+	if c == nil {
+		return fmt.Errorf("context %v is empty", from)
+	}
+	for _, ref := range []struct{ list, field, entry string }{
+		{"clusters", "cluster", "cluster"},
+		{"users", "user", "user"},
+	} {
+		refName, _ := c[ref.field].(string)
+		e := exported.find(ref.list, refName)
+		if e == nil {
+			return fmt.Errorf("%v %v not found in exported kubeconfig", ref.field, refName)
+		}
+		k.set(ref.list, map[string]interface{}{"name": name, ref.entry: e[ref.entry]})
+		c[ref.field] = name
+	}
+	c["extensions"] = []interface{}{map[string]interface{}{
+		"name":      kubeconfigExtension,
+		"extension": map[string]interface{}{"workspace": workspace},
+	}}
+	k.set("contexts", map[string]interface{}{"name": name, "context": c})
+	if cur, _ := k["current-context"].(string); cur == "" {
+		k["current-context"] = name
+	}
+	return nil
+}
+
+// prune removes contexts written by wk for workspace that are not in keep,
+// along with their clusters and users, and returns their names.
+func (k kubeconfig) prune(workspace string, keep map[string]bool) []string {
+	pruned := []string{}
+	for _, e := range k.list("contexts") {
+		m, _ := e.(map[string]interface{})
+		name, _ := m["name"].(string)
+		c, _ := m["context"].(map[string]interface{})
+		if c == nil || keep[name] || contextWorkspace(c) != workspace {
+			continue
+		}
+		pruned = append(pruned, name)
+	}
+	sort.Strings(pruned)
+	for _, name := range pruned {
+		k.remove("contexts", name)
+		k.remove("clusters", name)
+		k.remove("users", name)
+		if k["current-context"] == name {
+			k["current-context"] = ""
+		}
+	}
+	return pruned
+}
+
+// contextWorkspace returns the workspace recorded in a context written by wk
+func contextWorkspace(c map[string]interface{}) string {
+	exts, _ := c["extensions"].([]interface{})
+	for _, e := range exts {
+		m, _ := e.(map[string]interface{})
+		if m["name"] != kubeconfigExtension {
+			continue
+		}
+		ext, _ := m["extension"].(map[string]interface{})
+		w, _ := ext["workspace"].(string)
+		return w
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package kops
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestKubeconfigMergeAndPrune(t *testing.T) {
+	decode := func(s string) kubeconfig {
+		k := kubeconfig{}
+		if err := yaml.Unmarshal([]byte(s), &k); err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	target := decode(`
+apiVersion: v1
+kind: Config
+current-context: minikube
+contexts:
+- name: minikube
+  context: {cluster: minikube, user: minikube}
+- name: prod-old.k8s.local
+  context:
+    cluster: prod-old.k8s.local
+    user: prod-old.k8s.local
+    extensions: [{name: wk, extension: {workspace: /ws}}]
+- name: prod-other.k8s.local
+  context:
+    cluster: prod-other.k8s.local
+    user: prod-other.k8s.local
+    extensions: [{name: wk, extension: {workspace: /other}}]
+clusters:
+- name: minikube
+  cluster: {server: "https://192.168.99.100:8443"}
+- name: prod-old.k8s.local
+  cluster: {server: "https://old"}
+users:
+- name: minikube
+  user: {token: a}
+- name: prod-old.k8s.local
+  user: {token: b}
+`)
+	exported := decode(`
+contexts:
+- name: test.k8s.local
+  context: {cluster: test.k8s.local, user: test.k8s.local-basic-auth}
+clusters:
+- name: test.k8s.local
+  cluster: {server: "https://api.test.k8s.local"}
+users:
+- name: test.k8s.local-basic-auth
+  user: {username: admin}
+`)
+	if err := target.merge(exported, "test.k8s.local", "prod-test.k8s.local", "/ws"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := target.find("contexts", "prod-test.k8s.local")["context"].(map[string]interface{})
+	if ctx["cluster"] != "prod-test.k8s.local" || ctx["user"] != "prod-test.k8s.local" || contextWorkspace(ctx) != "/ws" {
+		t.Errorf("unexpected context %v", ctx)
+	}
+	if target.find("users", "prod-test.k8s.local") == nil || target.find("clusters", "prod-test.k8s.local") == nil {
+		t.Errorf("cluster or user not merged")
+	}
+
+	pruned := target.prune("/ws", map[string]bool{"prod-test.k8s.local": true})
+	if !reflect.DeepEqual(pruned, []string{"prod-old.k8s.local"}) {
+		t.Errorf("got pruned %v", pruned)
+	}
+	names := func(key string) []string {
+		out := []string{}
+		for _, e := range target.list(key) {
+			out = append(out, e.(map[string]interface{})["name"].(string))
+		}
+		return out
+	}
+	if got, want := names("contexts"), []string{"minikube", "prod-other.k8s.local", "prod-test.k8s.local"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got contexts %v, want %v", got, want)
+	}
+	if got, want := names("users"), []string{"minikube", "prod-test.k8s.local"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got users %v, want %v", got, want)
+	}
+	if target["current-context"] != "minikube" {
+		t.Errorf("current context changed to %v", target["current-context"])
+	}
+}
@@ -0,0 +1,248 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/kops/util/pkg/vfs"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// lockTTL is how long a lock stays valid without being renewed
+var lockTTL = 15 * time.Minute
+
+// lockSettle is how long to wait before checking a newly written lock is ours.
+// Not every state store can create files atomically.
+var lockSettle = 2 * time.Second
+
+// Lock is a lease on a cluster held by a wk run
+type Lock struct {
+	ID      string
+	Owner   string
+	Host    string
+	PID     int
+	Started time.Time
+	Expires time.Time
+}
+
+// Expired reports whether the lease ran out
+func (l *Lock) Expired() bool {
+	return time.Now().After(l.Expires)
+}
+
+func (l *Lock) String() string {
+	return fmt.Sprintf("held by %v@%v (pid %v) since %v, expires %v",
+		l.Owner, l.Host, l.PID, l.Started.Format(time.RFC3339), l.Expires.Format(time.RFC3339))
+}
+
+func lockPath(cluster *types.Cluster) (vfs.Path, error) {
+	p, err := stateStorePath(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return p.Join("lock.json"), nil
+}
+
+func readLock(p vfs.Path) (*Lock, error) {
+	b, err := p.ReadFile()
+	if err != nil {
+		return nil, err
+	}
+	l := &Lock{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("could not read lock %v: %v", p.Path(), err)
+	}
+	return l, nil
+}
+
+// acquireLock takes the cluster's lock in the state store. An expired lock
+// is taken over. The lease is renewed in the background until release is
+// called. The returned context is aborted if another run takes the lock, so
+// this run stops instead of racing it.
+func acquireLock(ctx context.Context, cluster *types.Cluster) (context.Context, func(), error) {
+	p, err := lockPath(cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	existing, err := readLock(p)
+	switch {
+	case err == nil && !existing.Expired():
+		return nil, nil, fmt.Errorf("cluster %v is locked: %v", cluster.Name, existing)
+	case err == nil:
+		logrus.Warnf("Taking over expired lock on cluster %v: %v", cluster.Name, existing)
+		if err := p.Remove(); err != nil {
+			return nil, nil, fmt.Errorf("could not remove expired lock: %v", err)
+		}
+	case !os.IsNotExist(err):
+		return nil, nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	l := &Lock{
+		ID:      fmt.Sprintf("%x", id),
+		Owner:   currentUser(),
+		Host:    currentHost(),
+		PID:     os.Getpid(),
+		Started: now,
+		Expires: now.Add(lockTTL),
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.CreateFile(bytes.NewReader(b), nil); err != nil {
+		if os.IsExist(err) {
+			return nil, nil, fmt.Errorf("cluster %v was locked concurrently", cluster.Name)
+		}
+		return nil, nil, fmt.Errorf("could not write lock: %v", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		if checkLockOwner(p, l) == nil {
+			if err := p.Remove(); err != nil {
+				logrus.Errorf("Could not release lock on cluster %v: %v", cluster.Name, err)
+			}
+		}
+		return nil, nil, ctx.Err()
+	case <-time.After(lockSettle):
+	}
+	if err := checkLockOwner(p, l); err != nil {
+		return nil, nil, fmt.Errorf("cluster %v was locked concurrently", cluster.Name)
+	}
+	logrus.Debugf("Locked cluster %v.", cluster.Name)
+
+	runCtx, abort := util.AbortableContext(ctx)
+	renewCtx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := renewLock(renewCtx, p, l); err != nil {
+			logrus.Errorf("Lost lock on cluster %v, stopping: %v", cluster.Name, err)
+			abort()
+		}
+	}()
+
+	return runCtx, func() {
+		cancel()
+		wg.Wait()
+		abort()
+		if err := checkLockOwner(p, l); err != nil {
+			logrus.Errorf("Not releasing lock on cluster %v: %v", cluster.Name, err)
+			return
+		}
+		if err := p.Remove(); err != nil {
+			logrus.Errorf("Could not release lock on cluster %v: %v", cluster.Name, err)
+			return
+		}
+		logrus.Debugf("Unlocked cluster %v.", cluster.Name)
+	}, nil
+}
+
+// checkLockOwner returns an error unless the lock in p is still l
+func checkLockOwner(p vfs.Path, l *Lock) error {
+	current, err := readLock(p)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Errorf("the lock was removed")
+	case err != nil:
+		return err
+	case current.ID != l.ID:
+		return fmt.Errorf("the lock is %v", current)
+	}
+	return nil
+}
+
+// renewLock extends the lease every third of lockTTL until ctx is done. The
+// lock is read before each renewal and an error returned once it is no longer
+// l, e.g. because it was broken or taken over after expiring.
+func renewLock(ctx context.Context, p vfs.Path, l *Lock) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(lockTTL / 3):
+		}
+		current, err := readLock(p)
+		switch {
+		case os.IsNotExist(err):
+			return fmt.Errorf("the lock was removed")
+		case err != nil:
+			logrus.Errorf("Could not renew lock: %v", err)
+			continue
+		case current.ID != l.ID:
+			return fmt.Errorf("the lock is %v", current)
+		}
+		l.Expires = time.Now().UTC().Add(lockTTL)
+		b, err := json.Marshal(l)
+		if err != nil {
+			logrus.Errorf("Could not renew lock: %v", err)
+			continue
+		}
+		if err := p.WriteFile(bytes.NewReader(b), nil); err != nil {
+			logrus.Errorf("Could not renew lock: %v", err)
+		}
+	}
+}
+
+// LockStatus prints the state of the cluster's lock
+func LockStatus(ctx context.Context, file string) error {
+	cluster, err := expandKopsCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	p, err := lockPath(cluster)
+	if err != nil {
+		return err
+	}
+	l, err := readLock(p)
+	switch {
+	case os.IsNotExist(err):
+		fmt.Printf("%v: unlocked\n", cluster.Name)
+	case err != nil:
+		return err
+	case l.Expired():
+		fmt.Printf("%v: expired lock %v\n", cluster.Name, l)
+	default:
+		fmt.Printf("%v: locked, %v\n", cluster.Name, l)
+	}
+	return nil
+}
+
+// LockBreak removes the cluster's lock regardless of its owner
+func LockBreak(ctx context.Context, file string) error {
+	cluster, err := expandKopsCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	p, err := lockPath(cluster)
+	if err != nil {
+		return err
+	}
+	l, err := readLock(p)
+	if os.IsNotExist(err) {
+		fmt.Printf("%v: unlocked\n", cluster.Name)
+		return nil
+	}
+	if err != nil {
+		logrus.Warnf("Removing unreadable lock: %v", err)
+	} else {
+		logrus.Infof("Breaking lock on %v %v", cluster.Name, l)
+	}
+	return p.Remove()
+}
@@ -0,0 +1,126 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockSettle = 0
+
+	cluster := &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "file://" + dir}},
+	}
+	_, release, err := acquireLock(context.Background(), cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := acquireLock(context.Background(), cluster); err == nil {
+		t.Fatal("expected second lock to fail")
+	}
+	release()
+
+	// Expired locks are taken over
+	p, err := lockPath(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(&Lock{ID: "stale", Expires: time.Now().Add(-time.Minute)})
+	if err := p.WriteFile(bytes.NewReader(b), nil); err != nil {
+		t.Fatal(err)
+	}
+	_, release, err = acquireLock(context.Background(), cluster)
+	if err != nil {
+		t.Fatalf("expired lock not taken over: %v", err)
+	}
+	release()
+
+	if _, err := readLock(p); !os.IsNotExist(err) {
+		t.Errorf("lock not removed on release: %v", err)
+	}
+}
+
+func TestLockInterrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockSettle = time.Hour
+	defer func() { lockSettle = 0 }()
+
+	cluster := &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "file://" + dir}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := acquireLock(ctx, cluster); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait for the lock to be interrupted, got %v", err)
+	}
+	p, err := lockPath(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readLock(p); !os.IsNotExist(err) {
+		t.Errorf("lock not removed after interrupt: %v", err)
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockSettle = 0
+	defer func(ttl time.Duration) { lockTTL = ttl }(lockTTL)
+	lockTTL = 30 * time.Millisecond
+
+	cluster := &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "file://" + dir}},
+	}
+	ctx, release, err := acquireLock(context.Background(), cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another run breaks the lock and takes it
+	p, err := lockPath(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(&Lock{ID: "other", Expires: time.Now().Add(time.Hour)})
+	if err := p.WriteFile(bytes.NewReader(b), nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected run to stop after losing the lock")
+	}
+	if util.AbortContext(ctx).Err() == nil {
+		t.Error("expected running step to be aborted after losing the lock")
+	}
+
+	release()
+	l, err := readLock(p)
+	if err != nil || l.ID != "other" {
+		t.Errorf("expected lock of the other run to be kept, got %v, %v", l, err)
+	}
+}
@@ -0,0 +1,187 @@
+package kops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/wk/pkg/util"
+)
+
+// ClusterResult is the outcome of applying a single cluster file
+type ClusterResult struct {
+	File     string
+	Changed  bool
+	Duration time.Duration
+	Err      error
+	// Report is the report written by the cluster's process, if any
+	Report *Report
+}
+
+// Status returns changed, unchanged or failed
+func (r ClusterResult) Status() string {
+	switch {
+	case r.Err != nil:
+		return "failed"
+	case r.Changed:
+		return "changed"
+	default:
+		return "unchanged"
+	}
+}
+
+// ExpandFiles expands glob patterns in files. Patterns matching nothing are an
+// error. Files given more than once, directly or through overlapping patterns,
+// are only returned the first time.
+func ExpandFiles(files []string) ([]string, error) {
+	out := []string{}
+	seen := map[string]bool{}
+	add := func(f string) error {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		if !seen[abs] {
+			seen[abs] = true
+			out = append(out, f)
+		}
+		return nil
+	}
+	for _, f := range files {
+		if !strings.ContainsAny(f, "*?[") {
+			if err := add(f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		matches, err := filepath.Glob(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %v", f)
+		}
+		for _, m := range matches {
+			if err := add(m); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// ClusterApplyMany applies several cluster files, at most parallel at a time.
+// Each cluster is applied by a separate `wk cluster` process run with args, so
+// kops calls for one cluster stay serialized. Output of each process is
+// prefixed with the cluster file name. If reportFile is set, the reports of all
+// clusters are written to it as a JSON list.
+func ClusterApplyMany(ctx context.Context, files, args []string, parallel int, reportFile string) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	ex, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not get executable: %v", err)
+	}
+
+	results := make([]ClusterResult, len(files))
+	outMu := &sync.Mutex{}
+	sem := make(chan struct{}, parallel)
+	wg := &sync.WaitGroup{}
+	for i, file := range files {
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				results[i] = ClusterResult{File: file, Err: fmt.Errorf("not started: %v", ctx.Err())}
+				return
+			}
+			results[i] = applyOne(ctx, ex, file, args, outMu)
+		}(i, file)
+	}
+	wg.Wait()
+
+	printClusterSummary(os.Stdout, results)
+	if reportFile != "" {
+		reports := []*Report{}
+		for _, r := range results {
+			if r.Report != nil {
+				reports = append(reports, r.Report)
+			}
+		}
+		if err := writeReports(reportFile, reports); err != nil {
+			return fmt.Errorf("could not write report: %v", err)
+		}
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v clusters failed", failed, len(results))
+	}
+	return nil
+}
+
+func applyOne(ctx context.Context, ex, file string, args []string, outMu *sync.Mutex) ClusterResult {
+	start := time.Now()
+	r := ClusterResult{File: file}
+
+	reportFile, err := util.WriteTempFile([]byte{})
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer os.Remove(reportFile)
+
+	prefix := "[" + strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + "] "
+	stdout := util.NewPrefixWriter(os.Stdout, prefix, outMu)
+	stderr := util.NewPrefixWriter(os.Stderr, prefix, outMu)
+	cmdArgs := append([]string{"cluster", "--report=" + reportFile}, args...)
+	// Each process handles interrupts itself, so it is only killed on abort
+	cCmd := exec.CommandContext(util.AbortContext(ctx), ex, append(cmdArgs, file)...)
+	cCmd.Stdout, cCmd.Stderr = stdout, stderr
+	r.Err = cCmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	r.Duration = time.Since(start)
+
+	// The report is written even if the cluster failed, unless it failed too
+	// early, e.g. on invalid flags
+	if report, err := readReport(reportFile); err == nil {
+		r.Report = report
+		r.Changed = report.changed()
+	} else if r.Err == nil {
+		logrus.Warnf("Could not read report of %v: %v", file, err)
+	}
+	return r
+}
+
+func printClusterSummary(out io.Writer, results []ClusterResult) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER FILE\tSTATUS\tDURATION\tERROR")
+	for _, r := range results {
+		errText := ""
+		if r.Report != nil && r.Report.Error != "" {
+			// The report tells why the cluster failed, not just how the process exited
+			errText = r.Report.Error
+		} else if r.Err != nil {
+			errText = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", r.File, r.Status(), r.Duration.Round(time.Second), errText)
+	}
+	w.Flush()
+}
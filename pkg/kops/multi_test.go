@@ -0,0 +1,56 @@
+package kops
+
+import (
+	"bytes"
+	stderrors "errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"a.jsonnet", "b.jsonnet"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := filepath.Join(dir, "a.jsonnet"), filepath.Join(dir, "b.jsonnet")
+
+	got, err := ExpandFiles([]string{a, filepath.Join(dir, "*.jsonnet"), filepath.Join(dir, "?.jsonnet"), filepath.Join(dir, ".", "b.jsonnet")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{a, b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := ExpandFiles([]string{filepath.Join(dir, "*.yaml")}); err == nil {
+		t.Error("expected pattern matching nothing to fail")
+	}
+}
+
+func TestPrintClusterSummary(t *testing.T) {
+	out := &bytes.Buffer{}
+	printClusterSummary(out, []ClusterResult{
+		{File: "a.jsonnet", Err: stderrors.New("exit status 1"), Report: &Report{Error: "could not update cluster: exit status 2"}},
+		{File: "b.jsonnet", Err: stderrors.New("exit status 1")},
+	})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected summary:\n%v", out)
+	}
+	if !strings.HasSuffix(lines[1], "could not update cluster: exit status 2") {
+		t.Errorf("expected error from report, got %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "exit status 1") {
+		t.Errorf("expected process error without report, got %q", lines[2])
+	}
+}
@@ -0,0 +1,166 @@
+package kops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/wk/pkg/jsonnet"
+	"github.com/wish/wk/pkg/opa"
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// Plan is a saved preview of a cluster apply. Applying a plan writes exactly
+// the rendered spec it contains, and only if the state store objects still
+// have the versions the plan's diffs were computed against.
+type Plan struct {
+	File      string
+	CreatedAt time.Time
+	// Exists is whether the cluster was present in the state store
+	Exists bool
+	// Spec is the rendered cluster file
+	Spec  *types.Cluster
+	State *State
+}
+
+// ClusterPlan renders the cluster file, previews the changes and saves them as a plan
+func ClusterPlan(ctx context.Context, file, planFile string, opaQuery *opa.OPA) error {
+	cluster, tfile, err := jsonnet.ExpandCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	if opaQuery != nil {
+		accepted, issues, err2 := opaQuery.RunFile(tfile)
+		if err2 != nil {
+			return err2
+		}
+		if !accepted {
+			for _, issue := range issues {
+				logrus.Errorf(issue)
+			}
+			return fmt.Errorf("Cluster failed OPA validation")
+		}
+	}
+	if err := validateSpec(file, cluster); err != nil {
+		return err
+	}
+
+	kopsEnv, err := clusterEnv(ctx, file, cluster)
+	if err != nil {
+		return err
+	}
+	exists, err := clusterExists(ctx, cluster.Name, kopsEnv)
+	if err != nil {
+		return err
+	}
+	var s *State
+	if exists {
+		if s, err = runEdits(ctx, cluster, file, tfile, "preview", kopsEnv, ClusterApplyOptions{}); err != nil {
+			return err
+		}
+	} else {
+		s = previewCreate(cluster)
+	}
+
+	plan := &Plan{
+		File:      file,
+		CreatedAt: time.Now().UTC(),
+		Exists:    exists,
+		Spec:      cluster,
+		State:     s,
+	}
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(planFile, b, 0644); err != nil {
+		return err
+	}
+	fmt.Println(s.renderDiffs())
+	logrus.Infof("Plan saved to %v.", planFile)
+	return nil
+}
+
+// ClusterApplyPlan applies a plan saved by ClusterPlan. It refuses to apply if
+// any object in the state store changed since the plan was made.
+func ClusterApplyPlan(ctx context.Context, planFile string, opts ClusterApplyOptions) (err error) {
+	opts.report = newReport(planFile, false)
+	defer func() { err = opts.report.finish(err, opts.ReportFile) }()
+
+	b, err := ioutil.ReadFile(planFile)
+	if err != nil {
+		return err
+	}
+	plan := &Plan{}
+	if err := json.Unmarshal(b, plan); err != nil {
+		return fmt.Errorf("could not read plan: %v", err)
+	}
+	if plan.Spec == nil || plan.Spec.Kops == nil || plan.State == nil {
+		return fmt.Errorf("plan %v is incomplete", planFile)
+	}
+	cluster := plan.Spec
+
+	sb, err := json.Marshal(cluster)
+	if err != nil {
+		return err
+	}
+	tfile, err := util.WriteTempFile(sb)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tfile)
+
+	ctx, release, err := acquireLock(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	kopsEnv, err := clusterEnv(ctx, plan.File, cluster)
+	if err != nil {
+		return err
+	}
+	exists, err := clusterExists(ctx, cluster.Name, kopsEnv)
+	if err != nil {
+		return err
+	}
+	if exists != plan.Exists {
+		return fmt.Errorf("cluster %v was created or deleted since the plan was made", cluster.Name)
+	}
+	if exists {
+		current, err := runEdits(ctx, cluster, plan.File, tfile, "preview", kopsEnv, opts)
+		if err != nil {
+			return err
+		}
+		if stale := staleObjects(plan.State, current); len(stale) > 0 {
+			return fmt.Errorf("state store changed since the plan was made: %v", stale)
+		}
+	}
+
+	opts.Create = !plan.Exists
+	// The plan was reviewed when it was made
+	opts.AutoApprove = true
+	return applyCluster(ctx, plan.File, cluster, tfile, opts)
+}
+
+// staleObjects returns the objects whose state store version differs between the states
+func staleObjects(planned, current *State) []string {
+	stale := []string{}
+	if planned.Cluster.Version != current.Cluster.Version {
+		stale = append(stale, "cluster")
+	}
+	for name, ig := range current.InstanceGroups {
+		if planned.InstanceGroups[name].Version != ig.Version {
+			stale = append(stale, "instance group "+name)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
@@ -0,0 +1,24 @@
+package kops
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStaleObjects(t *testing.T) {
+	planned := newState()
+	planned.Cluster.Version = "c1"
+	planned.InstanceGroups["nodes"] = ObjectState{Version: "n1"}
+	planned.InstanceGroups["masters"] = ObjectState{Version: "m1"}
+
+	current := newState()
+	current.Cluster.Version = "c1"
+	current.InstanceGroups["nodes"] = ObjectState{Version: "n2"}
+	current.InstanceGroups["masters"] = ObjectState{Version: "m1"}
+	current.InstanceGroups["extra"] = ObjectState{Version: "e1"}
+
+	want := []string{"instance group extra", "instance group nodes"}
+	if got := staleObjects(planned, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
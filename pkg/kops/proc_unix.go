@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package kops
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup runs cmd in its own process group, so an interrupt from the
+// terminal reaches only wk, which decides whether to let the command finish.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,7 @@
+package kops
+
+import "os/exec"
+
+// setProcessGroup is a no-op on windows, where console interrupts reach kops
+// as well
+func setProcessGroup(cmd *exec.Cmd) {}
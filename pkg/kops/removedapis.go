@@ -0,0 +1,167 @@
+package kops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"sigs.k8s.io/yaml"
+)
+
+// removedAPI is an API version of kinds that Kubernetes stopped serving
+type removedAPI struct {
+	apiVersion string
+	kinds      []string
+	// removed is the Kubernetes minor version that no longer serves it
+	removed string
+	// replacement is the API version to migrate to, if any
+	replacement string
+}
+
+var removedAPIs = []removedAPI{
+	{"extensions/v1beta1", []string{"DaemonSet", "Deployment", "ReplicaSet"}, "1.16", "apps/v1"},
+	{"extensions/v1beta1", []string{"NetworkPolicy"}, "1.16", "networking.k8s.io/v1"},
+	{"extensions/v1beta1", []string{"PodSecurityPolicy"}, "1.16", "policy/v1beta1"},
+	{"apps/v1beta1", []string{"Deployment", "StatefulSet"}, "1.16", "apps/v1"},
+	{"apps/v1beta2", []string{"DaemonSet", "Deployment", "ReplicaSet", "StatefulSet"}, "1.16", "apps/v1"},
+
+	{"extensions/v1beta1", []string{"Ingress"}, "1.22", "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", []string{"Ingress", "IngressClass"}, "1.22", "networking.k8s.io/v1"},
+	{"apiextensions.k8s.io/v1beta1", []string{"CustomResourceDefinition"}, "1.22", "apiextensions.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", []string{"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"}, "1.22", "admissionregistration.k8s.io/v1"},
+	{"apiregistration.k8s.io/v1beta1", []string{"APIService"}, "1.22", "apiregistration.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", []string{"ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding"}, "1.22", "rbac.authorization.k8s.io/v1"},
+	{"scheduling.k8s.io/v1beta1", []string{"PriorityClass"}, "1.22", "scheduling.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", []string{"CSIDriver", "CSINode", "StorageClass", "VolumeAttachment"}, "1.22", "storage.k8s.io/v1"},
+	{"certificates.k8s.io/v1beta1", []string{"CertificateSigningRequest"}, "1.22", "certificates.k8s.io/v1"},
+	{"coordination.k8s.io/v1beta1", []string{"Lease"}, "1.22", "coordination.k8s.io/v1"},
+
+	{"batch/v1beta1", []string{"CronJob"}, "1.25", "batch/v1"},
+	{"discovery.k8s.io/v1beta1", []string{"EndpointSlice"}, "1.25", "discovery.k8s.io/v1"},
+	{"events.k8s.io/v1beta1", []string{"Event"}, "1.25", "events.k8s.io/v1"},
+	{"autoscaling/v2beta1", []string{"HorizontalPodAutoscaler"}, "1.25", "autoscaling/v2"},
+	{"policy/v1beta1", []string{"PodDisruptionBudget"}, "1.25", "policy/v1"},
+	{"policy/v1beta1", []string{"PodSecurityPolicy"}, "1.25", ""},
+	{"node.k8s.io/v1beta1", []string{"RuntimeClass"}, "1.25", "node.k8s.io/v1"},
+
+	{"autoscaling/v2beta2", []string{"HorizontalPodAutoscaler"}, "1.26", "autoscaling/v2"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", []string{"FlowSchema", "PriorityLevelConfiguration"}, "1.26", "flowcontrol.apiserver.k8s.io/v1beta3"},
+	{"storage.k8s.io/v1beta1", []string{"CSIStorageCapacity"}, "1.27", "storage.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", []string{"FlowSchema", "PriorityLevelConfiguration"}, "1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", []string{"FlowSchema", "PriorityLevelConfiguration"}, "1.32", "flowcontrol.apiserver.k8s.io/v1"},
+}
+
+// documentSeparator splits the YAML streams of rendered apps
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// removedAPIUses lists the objects in the rendered channel apps in dir that
+// use APIs no longer served by Kubernetes version target
+func removedAPIUses(dir string, target semver.Version) ([]string, error) {
+	uses := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		found, err := findRemovedAPIs(b, target)
+		if err != nil {
+			return fmt.Errorf("could not read app %v: %v", rel, err)
+		}
+		for _, f := range found {
+			uses = append(uses, fmt.Sprintf("app %v: %v", rel, f))
+		}
+		return nil
+	})
+	sort.Strings(uses)
+	return uses, err
+}
+
+// findRemovedAPIs lists the objects in a YAML stream that use APIs no longer
+// served by Kubernetes version target
+func findRemovedAPIs(data []byte, target semver.Version) ([]string, error) {
+	found := []string{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch o := v.(type) {
+		case []interface{}:
+			for _, item := range o {
+				walk(item)
+			}
+		case map[string]interface{}:
+			apiVersion, _ := o["apiVersion"].(string)
+			kind, _ := o["kind"].(string)
+			if apiVersion == "" || kind == "" {
+				// Apps may render a map of objects
+				for _, item := range o {
+					walk(item)
+				}
+				return
+			}
+			if strings.HasSuffix(kind, "List") {
+				walk(o["items"])
+				return
+			}
+			if api := findRemovedAPI(apiVersion, kind, target); api != nil {
+				meta, _ := o["metadata"].(map[string]interface{})
+				name, _ := meta["name"].(string)
+				use := fmt.Sprintf("%v %v %v is removed in Kubernetes %v", apiVersion, kind, name, api.removed)
+				if api.replacement != "" {
+					use += ", use " + api.replacement
+				}
+				found = append(found, use)
+			}
+		}
+	}
+	for _, doc := range documentSeparator.Split(string(data), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(doc), &v); err != nil {
+			return nil, err
+		}
+		walk(v)
+	}
+	return found, nil
+}
+
+// findRemovedAPI returns the removed API of kind in apiVersion if it is no
+// longer served by Kubernetes version target
+func findRemovedAPI(apiVersion, kind string, target semver.Version) *removedAPI {
+	for i := range removedAPIs {
+		api := &removedAPIs[i]
+		if api.apiVersion != apiVersion {
+			continue
+		}
+		removed, err := semver.ParseTolerant(api.removed)
+		if err != nil || !minorAtLeast(target, removed) {
+			continue
+		}
+		for _, k := range api.kinds {
+			if k == kind {
+				return api
+			}
+		}
+	}
+	return nil
+}
+
+// minorAtLeast returns whether the minor version of v is at least that of o
+func minorAtLeast(v, o semver.Version) bool {
+	if v.Major != o.Major {
+		return v.Major > o.Major
+	}
+	return v.Minor >= o.Minor
+}
@@ -0,0 +1,249 @@
+package kops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wish/wk/pkg/specdiff"
+)
+
+const (
+	// ObjectUnchanged means the object matches the state store
+	ObjectUnchanged = "unchanged"
+	// ObjectChanged means the object differs from the state store
+	ObjectChanged = "changed"
+	// ObjectAdded means the object is missing from the state store
+	ObjectAdded = "added"
+)
+
+// Report is the result of a cluster apply, written as JSON by `--report`
+type Report struct {
+	File    string `json:"file"`
+	Cluster string `json:"cluster,omitempty"`
+	Preview bool   `json:"preview"`
+	// Created is set if the cluster was missing from the state store
+	Created bool           `json:"created"`
+	OPA     *OPAReport     `json:"opa,omitempty"`
+	Objects []ObjectReport `json:"objects"`
+	// SecretsChanged is set if any declared secret differed from the state store
+	SecretsChanged bool `json:"secretsChanged"`
+	// Cost is the estimated cost of the pending changes when previewing
+	Cost *CostEstimate `json:"cost,omitempty"`
+	// UpdateIssued is set if `kops update cluster` ran successfully
+	UpdateIssued bool         `json:"updateIssued"`
+	Steps        []StepReport `json:"steps"`
+	Started      time.Time    `json:"started"`
+	Seconds      float64      `json:"seconds"`
+	Error        string       `json:"error,omitempty"`
+	// Interrupted is the step that was interrupted or timed out, if any
+	Interrupted string `json:"interrupted,omitempty"`
+}
+
+// OPAReport is the result of the OPA query on the rendered cluster
+type OPAReport struct {
+	Accepted bool     `json:"accepted"`
+	Issues   []string `json:"issues"`
+}
+
+// ObjectReport is the change status of the cluster or an instance group
+type ObjectReport struct {
+	// Kind is cluster or instancegroup
+	Kind    string            `json:"kind"`
+	Name    string            `json:"name"`
+	Status  string            `json:"status"`
+	Changes []specdiff.Change `json:"changes"`
+}
+
+// StepReport is the timing of a single step of the apply
+type StepReport struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+	// Attempts is the number of times the step ran, more than one if retried
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// newReport starts the report of applying file. All methods of Report are
+// no-ops on a nil report, so code paths without a report need no checks.
+func newReport(file string, preview bool) *Report {
+	return &Report{
+		File:    file,
+		Preview: preview,
+		Objects: []ObjectReport{},
+		Steps:   []StepReport{},
+		Started: time.Now(),
+	}
+}
+
+// record adds a finished step to the report
+func (r *Report) record(name string, d time.Duration, attempts int, err error) {
+	if r == nil {
+		return
+	}
+	s := StepReport{Name: name, Seconds: d.Seconds(), Attempts: attempts}
+	if err != nil {
+		s.Error = err.Error()
+		if _, ok := err.(*StepError); ok {
+			r.Interrupted = name
+		}
+	}
+	r.Steps = append(r.Steps, s)
+}
+
+// interrupted records that the run stopped before step name
+func (r *Report) interrupted(name string) {
+	if r != nil {
+		r.Interrupted = name
+	}
+}
+
+func (r *Report) setOPA(accepted bool, issues []string) {
+	if r == nil {
+		return
+	}
+	if issues == nil {
+		issues = []string{}
+	}
+	r.OPA = &OPAReport{Accepted: accepted, Issues: issues}
+}
+
+// setState records the change status of the cluster and its instance groups
+func (r *Report) setState(s *State) {
+	if r == nil {
+		return
+	}
+	r.Objects = []ObjectReport{objectReport("cluster", r.Cluster, s.Cluster)}
+	names := make([]string, 0, len(s.InstanceGroups))
+	for name := range s.InstanceGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.Objects = append(r.Objects, objectReport("instancegroup", name, s.InstanceGroups[name]))
+	}
+}
+
+func objectReport(kind, name string, o ObjectState) ObjectReport {
+	status := ObjectUnchanged
+	if o.UpdateRequired {
+		status = ObjectChanged
+		// Objects read from the state store always have a version
+		if o.Version == "" {
+			status = ObjectAdded
+		}
+	}
+	changes := o.Changes
+	if changes == nil {
+		changes = []specdiff.Change{}
+	}
+	return ObjectReport{Kind: kind, Name: name, Status: status, Changes: changes}
+}
+
+// changed returns whether anything differed from the state store
+func (r *Report) changed() bool {
+	if r == nil {
+		return false
+	}
+	if r.Created || r.SecretsChanged {
+		return true
+	}
+	for _, o := range r.Objects {
+		if o.Status != ObjectUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// finish completes the report with the result of the apply, prints its
+// summary and writes it to path if set. It returns err unless writing the
+// report failed.
+func (r *Report) finish(err error, path string) error {
+	if r == nil {
+		return err
+	}
+	r.Seconds = time.Since(r.Started).Seconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	printReport(os.Stdout, r)
+	if path == "" {
+		return err
+	}
+	if writeErr := writeReports(path, r); writeErr != nil && err == nil {
+		return fmt.Errorf("could not write report: %v", writeErr)
+	}
+	return err
+}
+
+// writeReports writes a single report, or a list of reports, as JSON to path
+func writeReports(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+func readReport(path string) (*Report, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// printReport prints the human summary of a report
+func printReport(out io.Writer, r *Report) {
+	name := r.Cluster
+	if name == "" {
+		name = r.File
+	}
+	result := "unchanged"
+	switch {
+	case r.Interrupted != "":
+		result = "interrupted at step " + r.Interrupted
+	case r.Error != "":
+		result = "failed"
+	case r.changed() && r.Preview:
+		result = "changes pending"
+	case r.changed():
+		result = "changed"
+	}
+	if r.UpdateIssued {
+		result += ", update issued"
+	}
+	fmt.Fprintf(out, "\nSummary of %v: %v (%v)\n", name, result, seconds(r.Seconds))
+	if r.OPA != nil && !r.OPA.Accepted {
+		fmt.Fprintf(out, "OPA rejected the cluster with %v issue(s).\n", len(r.OPA.Issues))
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if len(r.Objects) > 0 {
+		fmt.Fprintln(w, "OBJECT\tSTATUS\tCHANGES")
+		for _, o := range r.Objects {
+			fmt.Fprintf(w, "%v/%v\t%v\t%v\n", o.Kind, o.Name, o.Status, len(o.Changes))
+		}
+		w.Flush()
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintln(w, "STEP\tDURATION\tATTEMPTS\tERROR")
+	for _, s := range r.Steps {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", s.Name, seconds(s.Seconds), s.Attempts, s.Error)
+	}
+	w.Flush()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
@@ -0,0 +1,95 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/specdiff"
+)
+
+func TestReportState(t *testing.T) {
+	s := newState()
+	s.Cluster = ObjectState{Version: "a"}
+	s.InstanceGroups["nodes"] = ObjectState{
+		UpdateRequired: true,
+		Changes:        []specdiff.Change{{Path: "spec.maxSize", Op: specdiff.Changed, Old: 3.0, New: 5.0}},
+		Version:        "b",
+	}
+	s.InstanceGroups["bastions"] = addedState(map[string]interface{}{"spec": map[string]interface{}{"role": "Bastion"}})
+
+	r := newReport("cluster.jsonnet", true)
+	r.Cluster = "test.k8s.local"
+	r.setState(s)
+
+	got := []string{}
+	for _, o := range r.Objects {
+		got = append(got, fmt.Sprintf("%v/%v %v %v", o.Kind, o.Name, o.Status, len(o.Changes)))
+	}
+	want := []string{
+		"cluster/test.k8s.local unchanged 0",
+		"instancegroup/bastions added 1",
+		"instancegroup/nodes changed 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !r.changed() {
+		t.Error("report should be changed")
+	}
+}
+
+func TestReportFinish(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.json")
+
+	r := newReport("cluster.jsonnet", false)
+	r.setOPA(true, nil)
+	stepErr := fmt.Errorf("kops failed")
+	if err := runStep(context.Background(), ClusterApplyOptions{report: r}, "update", 0, func(context.Context) error { return stepErr }); err != stepErr {
+		t.Fatalf("step returned %v", err)
+	}
+	if err := r.finish(stepErr, path); err != stepErr {
+		t.Fatalf("finish returned %v", err)
+	}
+
+	read, err := readReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Error != "kops failed" || read.OPA == nil || !read.OPA.Accepted {
+		t.Errorf("unexpected report %+v", read)
+	}
+	if len(read.Steps) != 1 || read.Steps[0].Name != "update" || read.Steps[0].Error != "kops failed" {
+		t.Errorf("unexpected steps %+v", read.Steps)
+	}
+
+	out := &bytes.Buffer{}
+	printReport(out, read)
+	if !strings.Contains(out.String(), "Summary of cluster.jsonnet: failed") {
+		t.Errorf("unexpected summary:\n%v", out)
+	}
+}
+
+func TestReportNil(t *testing.T) {
+	var r *Report
+	called := false
+	if err := runStep(context.Background(), ClusterApplyOptions{report: r}, "render", 0, func(context.Context) error { called = true; return nil }); err != nil || !called {
+		t.Error("step should run on a nil report")
+	}
+	r.setState(newState())
+	r.setOPA(false, nil)
+	if err := r.finish(nil, ""); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,203 @@
+package kops
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wish/wk/pkg/util"
+)
+
+// idempotentSteps are the steps that may be retried. Steps creating objects,
+// such as create, create-ig and secrets, or rolling instances are never
+// retried.
+var idempotentSteps = map[string]bool{
+	"check-exists": true,
+	"read-secrets": true,
+	"edit-cluster": true,
+	"edit-ig":      true,
+	"update":       true,
+}
+
+// defaultRetryPolicy retries cloud API throttling and network errors
+var defaultRetryPolicy = util.RetryPolicy{
+	Attempts:   3,
+	Backoff:    "10s",
+	MaxBackoff: "1m",
+	Patterns: []string{
+		`(?i)throttl`,
+		`RequestLimitExceeded`,
+		`(?i)rate exceeded`,
+		`(?i)too many requests`,
+		`connection reset by peer`,
+		`i/o timeout`,
+		`TLS handshake timeout`,
+		`(?i)service unavailable`,
+	},
+}
+
+// retryPolicy is a parsed util.RetryPolicy
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	patterns   []*regexp.Regexp
+	exitCodes  []int
+}
+
+// retryPolicies returns the retry policy of every idempotent step, as
+// configured in the workspace of file
+func retryPolicies(file string) (map[string]*retryPolicy, error) {
+	configured := map[string]util.RetryPolicy{}
+	if conf, err := util.GetConfig(file); err == nil && conf.Retry != nil {
+		configured = conf.Retry
+	}
+	for name := range configured {
+		if name != "default" && !idempotentSteps[name] {
+			steps := []string{}
+			for s := range idempotentSteps {
+				steps = append(steps, s)
+			}
+			sort.Strings(steps)
+			return nil, fmt.Errorf("invalid retry policy for step %v: only %v can be retried", name, strings.Join(steps, ", "))
+		}
+	}
+
+	def := mergeRetryPolicy(defaultRetryPolicy, configured["default"])
+	policies := map[string]*retryPolicy{}
+	for name := range idempotentSteps {
+		p, err := parseRetryPolicy(mergeRetryPolicy(def, configured[name]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry policy for step %v: %v", name, err)
+		}
+		policies[name] = p
+	}
+	return policies, nil
+}
+
+// mergeRetryPolicy returns base with the fields set in override replaced
+func mergeRetryPolicy(base, override util.RetryPolicy) util.RetryPolicy {
+	if override.Attempts != 0 {
+		base.Attempts = override.Attempts
+	}
+	if override.Backoff != "" {
+		base.Backoff = override.Backoff
+	}
+	if override.MaxBackoff != "" {
+		base.MaxBackoff = override.MaxBackoff
+	}
+	if override.Patterns != nil {
+		base.Patterns = override.Patterns
+	}
+	if override.ExitCodes != nil {
+		base.ExitCodes = override.ExitCodes
+	}
+	return base
+}
+
+func parseRetryPolicy(p util.RetryPolicy) (*retryPolicy, error) {
+	if p.Attempts < 1 {
+		return nil, fmt.Errorf("attempts must be at least 1")
+	}
+	rp := &retryPolicy{attempts: p.Attempts, exitCodes: p.ExitCodes}
+	var err error
+	if rp.backoff, err = time.ParseDuration(p.Backoff); err != nil {
+		return nil, err
+	}
+	if rp.maxBackoff, err = time.ParseDuration(p.MaxBackoff); err != nil {
+		return nil, err
+	}
+	for _, pattern := range p.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		rp.patterns = append(rp.patterns, re)
+	}
+	return rp, nil
+}
+
+// retryable reports whether the step failing with err and output may succeed
+// when run again. Interrupted and timed out steps are not retried.
+func (p *retryPolicy) retryable(err error, output string) bool {
+	if _, ok := err.(*StepError); ok {
+		return false
+	}
+	exitErr := &exec.ExitError{}
+	if stderrors.As(err, &exitErr) {
+		for _, code := range p.exitCodes {
+			if exitErr.ExitCode() == code {
+				return true
+			}
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(err.Error()) || re.MatchString(output) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the backoff before the retry following attempt
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d
+}
+
+// stepKind returns the name of a step without its object, e.g. edit-ig for
+// edit-ig/nodes
+func stepKind(name string) string {
+	return strings.SplitN(name, "/", 2)[0]
+}
+
+// maxOutputTail is the amount of step output kept for matching retry patterns
+const maxOutputTail = 64 * 1024
+
+// outputTail keeps the end of the output of a step
+type outputTail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > maxOutputTail {
+		t.buf = t.buf[len(t.buf)-maxOutputTail:]
+	}
+	return len(p), nil
+}
+
+func (t *outputTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+type outputTailKey struct{}
+
+// stepOutput returns the writers for the output of kops commands run by a
+// step: wk's stdout and stderr, also recorded for matching retry patterns.
+func stepOutput(ctx context.Context) (io.Writer, io.Writer) {
+	t, ok := ctx.Value(outputTailKey{}).(*outputTail)
+	if !ok {
+		return os.Stdout, os.Stderr
+	}
+	return io.MultiWriter(os.Stdout, t), io.MultiWriter(os.Stderr, t)
+}
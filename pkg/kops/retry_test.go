@@ -0,0 +1,101 @@
+package kops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, ".wk.yaml")
+	file := filepath.Join(dir, "cluster.jsonnet")
+
+	if err := ioutil.WriteFile(conf, []byte(`
+Retry:
+  default:
+    Attempts: 5
+  update:
+    Backoff: 1s
+    ExitCodes: [2]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := retryPolicies(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := policies["update"]
+	if update.attempts != 5 || update.backoff != time.Second || update.maxBackoff != time.Minute || len(update.exitCodes) != 1 {
+		t.Errorf("unexpected update policy %+v", update)
+	}
+	if edit := policies["edit-ig"]; edit.attempts != 5 || edit.backoff != 10*time.Second || len(edit.patterns) == 0 {
+		t.Errorf("unexpected edit-ig policy %+v", edit)
+	}
+	if _, ok := policies["create"]; ok {
+		t.Error("create must not be retried")
+	}
+
+	if err := ioutil.WriteFile(conf, []byte("Retry:\n  rolling-update:\n    Attempts: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retryPolicies(file); err == nil || !strings.Contains(err.Error(), "rolling-update") {
+		t.Errorf("expected error for non-idempotent step, got %v", err)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := &retryPolicy{backoff: 10 * time.Second, maxBackoff: 30 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 30 * time.Second, 6: 30 * time.Second} {
+		if d := p.delay(attempt); d != want {
+			t.Errorf("delay(%v) = %v, want %v", attempt, d, want)
+		}
+	}
+}
+
+func TestRunStepRetry(t *testing.T) {
+	log, cleanup := stubKops(t, `if [ $(wc -l < $(dirname $0)/calls) -lt 2 ]; then echo "Throttling: Rate exceeded" >&2; exit 1; fi`)
+	defer cleanup()
+
+	policy, err := parseRetryPolicy(defaultRetryPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.backoff = time.Millisecond
+	opts := ClusterApplyOptions{
+		report: newReport("cluster.jsonnet", false),
+		retry:  map[string]*retryPolicy{"update": policy},
+	}
+	err = runStep(context.Background(), opts, "update", 0, func(ctx context.Context) error {
+		return updateCluster(ctx, "", testCluster(), os.Environ())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := readCalls(t, log); len(calls) != 2 {
+		t.Errorf("expected 2 calls, got %q", calls)
+	}
+	if s := opts.report.Steps; len(s) != 1 || s[0].Attempts != 2 {
+		t.Errorf("unexpected steps %+v", s)
+	}
+
+	// Steps without a policy are not retried
+	os.Remove(log)
+	err = runStep(context.Background(), opts, "create", 0, func(ctx context.Context) error {
+		return updateCluster(ctx, "", testCluster(), os.Environ())
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if calls := readCalls(t, log); len(calls) != 1 {
+		t.Errorf("expected 1 call, got %q", calls)
+	}
+}
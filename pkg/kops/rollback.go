@@ -0,0 +1,93 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/kops/util/pkg/vfs"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+func snapshotPath(cluster *types.Cluster, revision string) (vfs.Path, error) {
+	p, err := stateStorePath(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return p.Join("snapshots", revision+".json"), nil
+}
+
+// writeSnapshot saves the rendered cluster file applied in the given revision
+func writeSnapshot(cluster *types.Cluster, revision, tfile string) error {
+	b, err := ioutil.ReadFile(tfile)
+	if err != nil {
+		return err
+	}
+	p, err := snapshotPath(cluster, revision)
+	if err != nil {
+		return err
+	}
+	return p.WriteFile(bytes.NewReader(b), nil)
+}
+
+// readSnapshot reads the rendered cluster file snapshotted in revision of the
+// current cluster
+func readSnapshot(current *types.Cluster, revision string) (*types.Cluster, []byte, error) {
+	p, err := snapshotPath(current, revision)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := p.ReadFile()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("no snapshot of revision %v for cluster %v, only successful applies can be rolled back to", revision, current.Name)
+		}
+		return nil, nil, err
+	}
+	cluster := &types.Cluster{}
+	if err := json.Unmarshal(b, cluster); err != nil {
+		return nil, nil, fmt.Errorf("could not read snapshot %v: %v", revision, err)
+	}
+	if cluster.Name != current.Name || cluster.Kops == nil {
+		return nil, nil, fmt.Errorf("snapshot %v is not of cluster %v", revision, current.Name)
+	}
+	return cluster, b, nil
+}
+
+// ClusterRollback re-applies the cluster and instance group specs snapshotted
+// in a previous successful revision, as listed by `wk history`.
+func ClusterRollback(ctx context.Context, file, revision string, opts ClusterApplyOptions) (err error) {
+	opts.report = newReport(file, opts.Preview)
+	defer func() { err = opts.report.finish(err, opts.ReportFile) }()
+
+	current, err := expandKopsCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	cluster, b, err := readSnapshot(current, revision)
+	if err != nil {
+		return err
+	}
+	tfile, err := util.WriteTempFile(b)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tfile)
+
+	logrus.Infof("Rolling cluster %v back to revision %v.", cluster.Name, revision)
+	if !opts.Preview {
+		lockCtx, release, err := acquireLock(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		defer release()
+		ctx = lockCtx
+	}
+	return applyCluster(ctx, file, cluster, tfile, opts)
+}
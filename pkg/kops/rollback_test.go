@@ -0,0 +1,56 @@
+package kops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestReadSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cluster := &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "file://" + dir}},
+	}
+	write := func(revision string, c *types.Cluster) {
+		b, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ioutil.TempFile("", "wk-snapshot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.Write(b)
+		f.Close()
+		if err := writeSnapshot(cluster, revision, f.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1", cluster)
+	write("2", &types.Cluster{Name: "other.k8s.local", Kops: &types.Kops{}})
+	if _, err := os.Stat(filepath.Join(dir, wkDir, "test.k8s.local", "snapshots", "1.json")); err != nil {
+		t.Fatalf("snapshot not written: %v", err)
+	}
+
+	if s, _, err := readSnapshot(cluster, "1"); err != nil || s.Name != cluster.Name {
+		t.Errorf("got snapshot %v, %v", s, err)
+	}
+	if _, _, err := readSnapshot(cluster, "3"); err == nil || !strings.Contains(err.Error(), "no snapshot of revision 3") {
+		t.Errorf("expected missing revision to be reported, got %v", err)
+	}
+	if _, _, err := readSnapshot(cluster, "2"); err == nil || !strings.Contains(err.Error(), "is not of cluster test.k8s.local") {
+		t.Errorf("expected snapshot of another cluster to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package kops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+// RollingUpdateOptions configures `kops rolling-update cluster` runs
+type RollingUpdateOptions struct {
+	// Groups are sets of instance group names rolled together, in order, after the masters
+	Groups [][]string
+	// InstanceGroups restricts the rolling update to the named instance groups
+	InstanceGroups []string
+	// Interval is the time to wait between restarting instances
+	Interval time.Duration
+	// CloudOnly skips draining and validating nodes against the Kubernetes API
+	CloudOnly bool
+	// FailOnValidate fails the rolling update if the cluster fails to validate
+	FailOnValidate bool
+}
+
+// RollingUpdateStep is a single `kops rolling-update cluster` invocation
+type RollingUpdateStep struct {
+	InstanceGroups []string
+	Duration       time.Duration
+	Err            error
+}
+
+// AddRollingUpdateOpts adds command line options for rolling updates to a command
+func AddRollingUpdateOpts(cmd *cobra.Command) {
+	cmd.Flags().StringArray("group", nil, "Comma separated instance groups rolled together after the masters, may be repeated")
+	cmd.Flags().StringSlice("ig", nil, "Only roll the given instance groups")
+	cmd.Flags().Duration("interval", 0, "Time to wait between restarting instances")
+	cmd.Flags().Bool("cloudonly", false, "Roll without draining and validating nodes")
+	cmd.Flags().Bool("fail-on-validate-error", true, "Fail if the cluster does not validate between instances")
+}
+
+// RollingUpdateFromFlags reads rolling update options added by AddRollingUpdateOpts
+func RollingUpdateFromFlags(flags *flag.FlagSet) (RollingUpdateOptions, error) {
+	opts := RollingUpdateOptions{}
+	groups, err := flags.GetStringArray("group")
+	if err != nil {
+		return opts, err
+	}
+	for _, g := range groups {
+		opts.Groups = append(opts.Groups, strings.Split(g, ","))
+	}
+	if opts.InstanceGroups, err = flags.GetStringSlice("ig"); err != nil {
+		return opts, err
+	}
+	if opts.Interval, err = flags.GetDuration("interval"); err != nil {
+		return opts, err
+	}
+	if opts.CloudOnly, err = flags.GetBool("cloudonly"); err != nil {
+		return opts, err
+	}
+	if opts.FailOnValidate, err = flags.GetBool("fail-on-validate-error"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// ClusterRollingUpdate renders the cluster file and rolls its instance groups
+func ClusterRollingUpdate(ctx context.Context, file string, opts RollingUpdateOptions) error {
+	cluster, err := expandKopsCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	igs := []string{}
+	for _, ig := range cluster.Kops.InstanceGroups {
+		igs = append(igs, ig.Name)
+	}
+	kopsEnv, err := clusterEnv(ctx, file, cluster)
+	if err != nil {
+		return err
+	}
+	return rollingUpdate(ctx, cluster, kopsEnv, igs, opts)
+}
+
+// rollingUpdateIGs returns the instance groups changed in the state. A change
+// to the cluster spec may affect every instance, so all groups are returned.
+func rollingUpdateIGs(cluster *types.Cluster, s *State) []string {
+	igs := []string{}
+	for _, ig := range cluster.Kops.InstanceGroups {
+		if s.Cluster.UpdateRequired || s.InstanceGroups[ig.Name].UpdateRequired {
+			igs = append(igs, ig.Name)
+		}
+	}
+	return igs
+}
+
+func rollingUpdate(ctx context.Context, cluster *types.Cluster, env []string, igs []string, opts RollingUpdateOptions) error {
+	if len(opts.InstanceGroups) > 0 {
+		igs = intersect(igs, opts.InstanceGroups)
+	}
+	steps := rollingOrder(cluster, igs, opts.Groups)
+	if len(steps) == 0 {
+		logrus.Infoln("No instance groups to roll.")
+		return nil
+	}
+
+	var failed error
+	for i := range steps {
+		step := &steps[i]
+		if failed != nil {
+			break
+		}
+		logrus.Infoln("Rolling instance groups:", strings.Join(step.InstanceGroups, ", "))
+
+		start := time.Now()
+		rCmd := kopsCommand(ctx, env, rollingUpdateArgs(cluster.Name, step.InstanceGroups, opts)...)
+		rCmd.Stdout, rCmd.Stderr = os.Stdout, os.Stderr
+		if err := rCmd.Run(); err != nil {
+			step.Err = err
+			failed = fmt.Errorf("could not roll instance groups %v: %v", strings.Join(step.InstanceGroups, ", "), err)
+		}
+		step.Duration = time.Since(start)
+	}
+
+	printRollingSummary(os.Stdout, steps)
+	return failed
+}
+
+func rollingUpdateArgs(name string, igs []string, opts RollingUpdateOptions) []string {
+	args := []string{"rolling-update", "cluster", "--name=" + name, "--yes",
+		"--instance-group=" + strings.Join(igs, ",")}
+	if opts.Interval > 0 {
+		args = append(args, "--master-interval="+opts.Interval.String(), "--node-interval="+opts.Interval.String())
+	}
+	if opts.CloudOnly {
+		args = append(args, "--cloudonly")
+	}
+	if !opts.FailOnValidate {
+		args = append(args, "--fail-on-validate-error=false")
+	}
+	return args
+}
+
+// rollingOrder splits instance groups into steps: masters first, then each of
+// the named groups and finally all remaining instance groups.
+func rollingOrder(cluster *types.Cluster, igs []string, groups [][]string) []RollingUpdateStep {
+	remaining := map[string]bool{}
+	for _, ig := range igs {
+		remaining[ig] = true
+	}
+	take := func(names []string) []string {
+		out := []string{}
+		for _, n := range names {
+			if remaining[n] {
+				out = append(out, n)
+				delete(remaining, n)
+			}
+		}
+		return out
+	}
+
+	masters := []string{}
+	for _, ig := range cluster.Kops.InstanceGroups {
+		if igRole(ig) == "Master" {
+			masters = append(masters, ig.Name)
+		}
+	}
+
+	steps := []RollingUpdateStep{}
+	for _, names := range append([][]string{masters}, groups...) {
+		if taken := take(names); len(taken) > 0 {
+			steps = append(steps, RollingUpdateStep{InstanceGroups: taken})
+		}
+	}
+	if rest := take(igs); len(rest) > 0 {
+		steps = append(steps, RollingUpdateStep{InstanceGroups: rest})
+	}
+	return steps
+}
+
+func igRole(ig types.InstanceGroup) string {
+	spec, _ := ig.Value["spec"].(map[string]interface{})
+	role, _ := spec["role"].(string)
+	return role
+}
+
+func intersect(a, b []string) []string {
+	in := map[string]bool{}
+	for _, s := range b {
+		in[s] = true
+	}
+	out := []string{}
+	for _, s := range a {
+		if in[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func printRollingSummary(out io.Writer, steps []RollingUpdateStep) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE GROUPS\tSTATUS\tDURATION")
+	for _, step := range steps {
+		status := "rolled"
+		switch {
+		case step.Err != nil:
+			status = "failed"
+		case step.Duration == 0:
+			status = "skipped"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", strings.Join(step.InstanceGroups, ","), status, step.Duration.Round(time.Second))
+	}
+	w.Flush()
+}
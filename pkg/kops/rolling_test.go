@@ -0,0 +1,48 @@
+package kops
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRollingUpdate(t *testing.T) {
+	log, cleanup := stubKops(t, "")
+	defer cleanup()
+	cluster := testCluster()
+	s := newState()
+	s.InstanceGroups["nodes-a"] = ObjectState{UpdateRequired: true}
+	s.InstanceGroups["nodes-b"] = ObjectState{UpdateRequired: true}
+	s.InstanceGroups["master-b"] = ObjectState{UpdateRequired: true}
+
+	opts := RollingUpdateOptions{Groups: [][]string{{"nodes-b"}}, FailOnValidate: true}
+	if err := rollingUpdate(context.Background(), cluster, os.Environ(), rollingUpdateIGs(cluster, s), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"rolling-update cluster --name=test.k8s.local --yes --instance-group=master-b",
+		"rolling-update cluster --name=test.k8s.local --yes --instance-group=nodes-b",
+		"rolling-update cluster --name=test.k8s.local --yes --instance-group=nodes-a",
+	}
+	if got := readCalls(t, log); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got calls:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRollingUpdateStopsOnFailure(t *testing.T) {
+	log, cleanup := stubKops(t, `case "$*" in *master*) exit 1;; esac`)
+	defer cleanup()
+	cluster := testCluster()
+	igs := []string{"nodes-a", "master-a", "bastion"}
+
+	err := rollingUpdate(context.Background(), cluster, os.Environ(), igs, RollingUpdateOptions{CloudOnly: true})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	want := "rolling-update cluster --name=test.k8s.local --yes --instance-group=master-a --cloudonly --fail-on-validate-error=false"
+	if got := readCalls(t, log); len(got) != 1 || got[0] != want {
+		t.Errorf("got calls %q, want %q", got, want)
+	}
+}
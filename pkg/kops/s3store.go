@@ -0,0 +1,251 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"k8s.io/kops/util/pkg/vfs"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+// s3Store is an S3 bucket of a cluster's state store. Its client is configured
+// from the cluster's kops environment, the way kops itself runs for the
+// cluster, instead of the shared vfs context that reads the environment of wk.
+type s3Store struct {
+	getenv func(string) string
+	bucket string
+
+	mu     sync.Mutex
+	client *s3.S3
+}
+
+var (
+	s3StoresMu sync.Mutex
+	// s3Stores holds the stores opened so far by cluster name and bucket
+	s3Stores = map[string]*s3Store{}
+)
+
+// clusterS3Store returns the store of bucket for the cluster, reusing its client
+func clusterS3Store(cluster *types.Cluster, getenv func(string) string, bucket string) *s3Store {
+	s3StoresMu.Lock()
+	defer s3StoresMu.Unlock()
+	key := cluster.Name + "/" + bucket
+	if s, ok := s3Stores[key]; ok {
+		return s
+	}
+	s := &s3Store{getenv: getenv, bucket: bucket}
+	s3Stores[key] = s
+	return s
+}
+
+func (s *s3Store) s3Client() (*s3.S3, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	config := aws.NewConfig().WithCredentialsChainVerboseErrors(true)
+	if endpoint := s.getenv("S3_ENDPOINT"); endpoint != "" {
+		// S3 compatible stores other than AWS, configured as kops expects
+		region := s.getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		config = config.WithEndpoint(endpoint).WithRegion(region).WithS3ForcePathStyle(true).
+			WithCredentials(credentials.NewStaticCredentials(s.getenv("S3_ACCESS_KEY_ID"), s.getenv("S3_SECRET_ACCESS_KEY"), ""))
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not start S3 session: %v", err)
+		}
+		s.client = s3.New(sess)
+		return s.client, nil
+	}
+
+	if id := s.getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(id, s.getenv("AWS_SECRET_ACCESS_KEY"), s.getenv("AWS_SESSION_TOKEN")))
+	}
+	opts := session.Options{
+		Config:            *config,
+		Profile:           s.getenv("AWS_PROFILE"),
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	for _, f := range []string{s.getenv("AWS_SHARED_CREDENTIALS_FILE"), s.getenv("AWS_CONFIG_FILE")} {
+		if f != "" {
+			opts.SharedConfigFiles = append(opts.SharedConfigFiles, f)
+		}
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not start AWS session: %v", err)
+	}
+
+	hint := s.getenv("AWS_REGION")
+	if hint == "" {
+		hint = s.getenv("AWS_DEFAULT_REGION")
+	}
+	if hint == "" {
+		hint = "us-east-1"
+	}
+	region, err := s3manager.GetBucketRegion(context.Background(), sess, s.bucket, hint)
+	if err != nil {
+		return nil, fmt.Errorf("could not get region of bucket %v: %v", s.bucket, err)
+	}
+	s.client = s3.New(sess, aws.NewConfig().WithRegion(region))
+	return s.client, nil
+}
+
+// s3StorePath is a vfs.Path in an s3Store
+type s3StorePath struct {
+	store *s3Store
+	key   string
+}
+
+var _ vfs.Path = &s3StorePath{}
+
+func (p *s3StorePath) Path() string {
+	return "s3://" + p.store.bucket + "/" + p.key
+}
+
+func (p *s3StorePath) Base() string {
+	return path.Base(p.key)
+}
+
+func (p *s3StorePath) Join(relativePath ...string) vfs.Path {
+	return &s3StorePath{store: p.store, key: path.Join(append([]string{p.key}, relativePath...)...)}
+}
+
+func (p *s3StorePath) ReadFile() ([]byte, error) {
+	b := &bytes.Buffer{}
+	if _, err := p.WriteTo(b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (p *s3StorePath) WriteTo(out io.Writer) (int64, error) {
+	client, err := p.store.s3Client()
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String(p.store.bucket), Key: aws.String(p.key)})
+	if err != nil {
+		if isS3NotFound(err) {
+			return 0, os.ErrNotExist
+		}
+		return 0, fmt.Errorf("could not read %v: %v", p.Path(), err)
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(out, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("could not read %v: %v", p.Path(), err)
+	}
+	return n, nil
+}
+
+func (p *s3StorePath) WriteFile(data io.ReadSeeker, acl vfs.ACL) error {
+	client, err := p.store.s3Client()
+	if err != nil {
+		return err
+	}
+	req := &s3.PutObjectInput{Bucket: aws.String(p.store.bucket), Key: aws.String(p.key), Body: data}
+	if a := strings.TrimSpace(p.store.getenv("KOPS_STATE_S3_ACL")); a != "" {
+		req.ACL = aws.String(a)
+	} else if a, ok := acl.(*vfs.S3Acl); ok && a != nil {
+		req.ACL = a.RequestACL
+	}
+	if _, err := client.PutObject(req); err != nil {
+		return fmt.Errorf("could not write %v: %v", p.Path(), err)
+	}
+	return nil
+}
+
+// CreateFile writes the file unless it exists. Like the S3 paths of kops, the
+// check and the write are not atomic.
+func (p *s3StorePath) CreateFile(data io.ReadSeeker, acl vfs.ACL) error {
+	client, err := p.store.s3Client()
+	if err != nil {
+		return err
+	}
+	_, err = client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(p.store.bucket), Key: aws.String(p.key)})
+	if err == nil {
+		return os.ErrExist
+	}
+	if !isS3NotFound(err) {
+		return fmt.Errorf("could not read %v: %v", p.Path(), err)
+	}
+	return p.WriteFile(data, acl)
+}
+
+func (p *s3StorePath) Remove() error {
+	client, err := p.store.s3Client()
+	if err != nil {
+		return err
+	}
+	if _, err := client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(p.store.bucket), Key: aws.String(p.key)}); err != nil {
+		return fmt.Errorf("could not delete %v: %v", p.Path(), err)
+	}
+	return nil
+}
+
+func (p *s3StorePath) ReadDir() ([]vfs.Path, error) {
+	return p.list(true)
+}
+
+func (p *s3StorePath) ReadTree() ([]vfs.Path, error) {
+	return p.list(false)
+}
+
+// list lists the files under the path. If dir is set, only its direct
+// children are listed, including its subdirectories.
+func (p *s3StorePath) list(dir bool) ([]vfs.Path, error) {
+	client, err := p.store.s3Client()
+	if err != nil {
+		return nil, err
+	}
+	prefix := p.key
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	req := &s3.ListObjectsInput{Bucket: aws.String(p.store.bucket), Prefix: aws.String(prefix)}
+	if dir {
+		req.Delimiter = aws.String("/")
+	}
+	paths := []vfs.Path{}
+	err = client.ListObjectsPages(req, func(page *s3.ListObjectsOutput, last bool) bool {
+		for _, o := range page.Contents {
+			// Directories created as files by other tools are skipped
+			if key := aws.StringValue(o.Key); key != prefix {
+				paths = append(paths, &s3StorePath{store: p.store, key: key})
+			}
+		}
+		for _, d := range page.CommonPrefixes {
+			paths = append(paths, &s3StorePath{store: p.store, key: strings.TrimSuffix(aws.StringValue(d.Prefix), "/")})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list %v: %v", p.Path(), err)
+	}
+	return paths, nil
+}
+
+func isS3NotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound"
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package kops
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/kops/util/pkg/vfs"
+)
+
+func TestS3StorePathList(t *testing.T) {
+	keys := []string{"clusters/wk/a.k8s.local/lock.json", "clusters/wk/a.k8s.local/history/1.json", "clusters/wk/a.k8s.local/history/2.json"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/store" {
+			http.NotFound(w, r)
+			return
+		}
+		prefix, delimiter := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+		contents, prefixes := "", map[string]bool{}
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+				prefixes[key[:len(prefix)+i+1]] = true
+				continue
+			}
+			contents += fmt.Sprintf("<Contents><Key>%v</Key></Contents>", key)
+		}
+		for p := range prefixes {
+			contents += fmt.Sprintf("<CommonPrefixes><Prefix>%v</Prefix></CommonPrefixes>", p)
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>store</Name><IsTruncated>false</IsTruncated>%v</ListBucketResult>`, contents)
+	}))
+	defer server.Close()
+
+	env := map[string]string{"S3_ENDPOINT": server.URL, "S3_ACCESS_KEY_ID": "id", "S3_SECRET_ACCESS_KEY": "secret"}
+	store := &s3Store{getenv: func(k string) string { return env[k] }, bucket: "store"}
+	p := &s3StorePath{store: store, key: "clusters/wk/a.k8s.local"}
+
+	dir, err := p.ReadDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"s3://store/clusters/wk/a.k8s.local/history", "s3://store/clusters/wk/a.k8s.local/lock.json"}
+	if got := sortedPaths(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got directory %q, want %q", got, want)
+	}
+	tree, err := p.ReadTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"s3://store/" + keys[1], "s3://store/" + keys[2], "s3://store/" + keys[0]}
+	if got := sortedPaths(tree); !reflect.DeepEqual(got, want) {
+		t.Errorf("got tree %q, want %q", got, want)
+	}
+}
+
+func sortedPaths(paths []vfs.Path) []string {
+	out := []string{}
+	for _, p := range paths {
+		out = append(out, p.Path())
+	}
+	sort.Strings(out)
+	return out
+}
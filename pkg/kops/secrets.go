@@ -0,0 +1,260 @@
+package kops
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+const (
+	// SecretSSHPublicKey is the kops secret type of SSH public keys
+	SecretSSHPublicKey = "sshpublickey"
+	// SecretDockerConfig is the kops secret type of the docker config
+	SecretDockerConfig = "dockerconfig"
+)
+
+// secretStatus compares a declared secret with the state store. Only
+// fingerprints are kept, never secret values.
+type secretStatus struct {
+	Secret types.Secret
+	// Path is the local file of the secret
+	Path string
+	// Fingerprint is the fingerprint of the local file
+	Fingerprint string
+	// Current are the fingerprints in the state store
+	Current []string
+}
+
+func (s *secretStatus) inSync() bool {
+	return len(s.Current) == 1 && s.Current[0] == s.Fingerprint
+}
+
+func (s *secretStatus) String() string {
+	id := s.Secret.Type + "/" + s.Secret.Name
+	switch {
+	case len(s.Current) == 0:
+		return fmt.Sprintf("Secret %v missing from state store (local %v)", id, s.Fingerprint)
+	case s.inSync():
+		return fmt.Sprintf("Secret %v up to date (%v)", id, s.Fingerprint)
+	}
+	return fmt.Sprintf("Secret %v fingerprint mismatch (state store %v, local %v)", id, strings.Join(s.Current, ", "), s.Fingerprint)
+}
+
+// secretStatuses reads the cluster's declared secrets and compares them with
+// the state store. If exists is false the state store is not read.
+func secretStatuses(file string, cluster *types.Cluster, exists bool) ([]*secretStatus, error) {
+	statuses := []*secretStatus{}
+	for _, secret := range cluster.Kops.Secrets {
+		switch secret.Type {
+		case SecretSSHPublicKey:
+			if secret.Name == "" {
+				secret.Name = "admin"
+			}
+		case SecretDockerConfig:
+			// kops stores a single docker config under a fixed name
+			if secret.Name != "" && secret.Name != "dockerconfig" {
+				return nil, fmt.Errorf("secret %v must be named dockerconfig, not %q", secret.Type, secret.Name)
+			}
+			secret.Name = "dockerconfig"
+		default:
+			return nil, fmt.Errorf("unsupported secret type %q", secret.Type)
+		}
+
+		path := secret.File
+		if !filepath.IsAbs(path) {
+			ctxDir, err := util.GetContextDir(file)
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(ctxDir, path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read secret %v/%v: %v", secret.Type, secret.Name, err)
+		}
+		fp, err := secretFingerprint(secret.Type, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret %v/%v in %v: %v", secret.Type, secret.Name, secret.File, err)
+		}
+
+		s := &secretStatus{Secret: secret, Path: path, Fingerprint: fp}
+		if exists {
+			if s.Current, err = storedFingerprints(cluster, secret); err != nil {
+				return nil, fmt.Errorf("could not read secret %v/%v from state store: %v", secret.Type, secret.Name, err)
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// secretFingerprint computes the fingerprint kops uses for SSH public keys,
+// and the sha256 of the file for the docker config
+func secretFingerprint(kind string, data []byte) (string, error) {
+	if kind == SecretSSHPublicKey {
+		key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return "", err
+		}
+		h := md5.Sum(key.Marshal())
+		parts := make([]string, len(h))
+		for i, b := range h {
+			parts[i] = fmt.Sprintf("%02x", b)
+		}
+		return strings.Join(parts, ":"), nil
+	}
+	if !json.Valid(data) {
+		return "", fmt.Errorf("docker config is not valid JSON")
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// storedFingerprints returns the fingerprints of the secret in the state store
+func storedFingerprints(cluster *types.Cluster, secret types.Secret) ([]string, error) {
+	base, err := clusterStorePath(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret.Type == SecretSSHPublicKey {
+		keys, err := storedSSHKeys(cluster, secret.Name)
+		if err != nil {
+			return nil, err
+		}
+		fps := []string{}
+		for _, data := range keys {
+			fp, err := secretFingerprint(secret.Type, data)
+			if err != nil {
+				return nil, err
+			}
+			fps = append(fps, fp)
+		}
+		return fps, nil
+	}
+
+	data, err := base.Join("secrets", "dockerconfig").ReadFile()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	stored := struct{ Data []byte }{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("sha256:%x", sha256.Sum256(stored.Data))}, nil
+}
+
+// storedSSHKeys returns the SSH public keys named name in the state store
+func storedSSHKeys(cluster *types.Cluster, name string) ([][]byte, error) {
+	base, err := clusterStorePath(cluster)
+	if err != nil {
+		return nil, err
+	}
+	files, err := base.Join("pki", "ssh", "public", name).ReadDir()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	keys := [][]byte{}
+	for _, f := range files {
+		data, err := f.ReadFile()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, data)
+	}
+	return keys, nil
+}
+
+// applySecrets makes the state store secrets match the declared ones, as
+// compared by secretStatuses. It returns whether any secret changed. In
+// preview mode the differences are only reported.
+func applySecrets(ctx context.Context, cluster *types.Cluster, env []string, statuses []*secretStatus, preview bool) (bool, error) {
+	changed := false
+	for _, s := range statuses {
+		if s.inSync() {
+			logrus.Debugln(s)
+			continue
+		}
+		logrus.Infof("%v.", s)
+		if preview {
+			changed = true
+			continue
+		}
+
+		if s.Secret.Type == SecretSSHPublicKey {
+			// kops only allows a single key of a name, so the old one is
+			// deleted first and restored if the new one cannot be created
+			var previous [][]byte
+			if len(s.Current) > 0 {
+				var err error
+				if previous, err = storedSSHKeys(cluster, s.Secret.Name); err != nil {
+					return changed, fmt.Errorf("could not read secret %v/%v from state store: %v", s.Secret.Type, s.Secret.Name, err)
+				}
+				dCmd := kopsCommand(ctx, env, "delete", "secret", SecretSSHPublicKey, s.Secret.Name, "--name="+cluster.Name)
+				dCmd.Stdout, dCmd.Stderr = os.Stdout, os.Stderr
+				if err := dCmd.Run(); err != nil {
+					return changed, fmt.Errorf("could not delete secret %v/%v: %v", s.Secret.Type, s.Secret.Name, err)
+				}
+			}
+			if err := createSSHKey(ctx, cluster, env, s.Secret.Name, s.Path); err != nil {
+				err = fmt.Errorf("could not create secret %v/%v: %v", s.Secret.Type, s.Secret.Name, err)
+				if len(previous) == 0 {
+					return changed, err
+				}
+				// The key is restored even if the step was aborted
+				if restoreErr := restoreSSHKeys(context.Background(), cluster, env, s.Secret.Name, previous); restoreErr != nil {
+					return true, fmt.Errorf("%v; could not restore the previous key either: %v", err, restoreErr)
+				}
+				logrus.Warnf("Restored the previous %v/%v key.", s.Secret.Type, s.Secret.Name)
+				return changed, err
+			}
+		} else {
+			cCmd := kopsCommand(ctx, env, "create", "secret", SecretDockerConfig, "-f", s.Path, "--force", "--name="+cluster.Name)
+			cCmd.Stdout, cCmd.Stderr = os.Stdout, os.Stderr
+			if err := cCmd.Run(); err != nil {
+				return changed, fmt.Errorf("could not create secret %v: %v", s.Secret.Type, err)
+			}
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+func createSSHKey(ctx context.Context, cluster *types.Cluster, env []string, name, path string) error {
+	cCmd := kopsCommand(ctx, env, "create", "secret", SecretSSHPublicKey, name, "-i", path, "--name="+cluster.Name)
+	cCmd.Stdout, cCmd.Stderr = os.Stdout, os.Stderr
+	return cCmd.Run()
+}
+
+// restoreSSHKeys creates the SSH public keys read from the state store again
+func restoreSSHKeys(ctx context.Context, cluster *types.Cluster, env []string, name string, keys [][]byte) error {
+	for _, key := range keys {
+		f, err := util.WriteTempFile(key)
+		if err != nil {
+			return err
+		}
+		err = createSSHKey(ctx, cluster, env, name, f)
+		os.Remove(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package kops
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestApplySecrets(t *testing.T) {
+	store, err := ioutil.TempDir("", "wk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+	workspace, err := ioutil.TempDir("", "wk-workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	log, cleanup := stubKops(t, "")
+	defer cleanup()
+
+	write := func(path string, data []byte) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sshKey := func() []byte {
+		k, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := ssh.NewPublicKey(&k.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ssh.MarshalAuthorizedKey(pub)
+	}
+	write(filepath.Join(workspace, ".wk.yaml"), nil)
+	local := sshKey()
+	write(filepath.Join(workspace, "id_rsa.pub"), local)
+	docker := []byte(`{"auths": {"registry": {"auth": "c2VjcmV0"}}}`)
+	write(filepath.Join(workspace, "docker.json"), docker)
+
+	// The SSH key in the store differs, the docker config matches
+	write(filepath.Join(store, "test.k8s.local/pki/ssh/public/admin/0011"), sshKey())
+	stored, _ := json.Marshal(struct{ Data []byte }{docker})
+	write(filepath.Join(store, "test.k8s.local/secrets/dockerconfig"), stored)
+
+	cluster := &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{
+			Env: map[string]string{"KOPS_STATE_STORE": "file://" + store},
+			Secrets: []types.Secret{
+				{Type: SecretSSHPublicKey, File: "id_rsa.pub"},
+				{Type: SecretDockerConfig, File: "docker.json"},
+			},
+		},
+	}
+	file := filepath.Join(workspace, "cluster.jsonnet")
+
+	statuses, err := secretStatuses(file, cluster, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].inSync() || !statuses[1].inSync() {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+	for _, s := range statuses {
+		if strings.Contains(s.String(), "c2VjcmV0") || strings.Contains(s.String(), "ssh-rsa") {
+			t.Errorf("secret value in %q", s)
+		}
+	}
+
+	changed, err := applySecrets(context.Background(), cluster, os.Environ(), statuses, true)
+	if err != nil || !changed {
+		t.Fatalf("expected preview change, got %v %v", changed, err)
+	}
+	if _, err := os.Stat(log); !os.IsNotExist(err) {
+		t.Errorf("kops was run in preview")
+	}
+
+	if _, err := applySecrets(context.Background(), cluster, os.Environ(), statuses, false); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"delete secret sshpublickey admin --name=test.k8s.local",
+		"create secret sshpublickey admin -i " + filepath.Join(workspace, "id_rsa.pub") + " --name=test.k8s.local",
+	}
+	if calls := readCalls(t, log); !reflect.DeepEqual(calls, want) {
+		t.Errorf("got %q, want %q", calls, want)
+	}
+
+	cluster.Kops.Secrets = append(cluster.Kops.Secrets, types.Secret{Type: SecretDockerConfig, Name: "registry", File: "docker.json"})
+	if _, err := secretStatuses(file, cluster, true); err == nil || !strings.Contains(err.Error(), "must be named dockerconfig") {
+		t.Errorf("expected misnamed docker config to be rejected, got %v", err)
+	}
+}
+
+func TestApplySecretsRestoresSSHKey(t *testing.T) {
+	store, err := ioutil.TempDir("", "wk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+	previous := filepath.Join(store, "test.k8s.local/pki/ssh/public/admin/0011")
+	os.MkdirAll(filepath.Dir(previous), 0755)
+	if err := ioutil.WriteFile(previous, []byte("ssh-rsa previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Creating the new key fails, restoring the previous one works
+	log, cleanup := stubKops(t, `case "$*" in *new.pub*) exit 1;; esac`)
+	defer cleanup()
+
+	cluster := &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "file://" + store}},
+	}
+	statuses := []*secretStatus{{
+		Secret:      types.Secret{Type: SecretSSHPublicKey, Name: "admin"},
+		Path:        "/keys/new.pub",
+		Fingerprint: "new",
+		Current:     []string{"previous"},
+	}}
+	_, err = applySecrets(context.Background(), cluster, os.Environ(), statuses, false)
+	if err == nil || !strings.Contains(err.Error(), "could not create secret sshpublickey/admin") {
+		t.Fatalf("expected create to fail, got %v", err)
+	}
+	calls := readCalls(t, log)
+	if len(calls) != 3 || calls[0] != "delete secret sshpublickey admin --name=test.k8s.local" ||
+		calls[1] != "create secret sshpublickey admin -i /keys/new.pub --name=test.k8s.local" ||
+		!strings.HasPrefix(calls[2], "create secret sshpublickey admin -i ") || strings.Contains(calls[2], "new.pub") {
+		t.Errorf("expected previous key to be restored, got calls %q", calls)
+	}
+}
@@ -0,0 +1,495 @@
+package kops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/wish/wk/pkg/jsonnet"
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// kopsCluster and kopsInstanceGroup are the subset of kops/v1alpha2 used by
+// wk. The typed k8s.io/kops/pkg/apis/kops objects and their validation are not
+// used: the validation package imports the kops cloud providers, which cannot
+// be resolved as modules. kops validates the full specs when wk edits them.
+
+type kopsMetadata struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+}
+
+type kopsCluster struct {
+	APIVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   kopsMetadata `json:"metadata"`
+	Spec       struct {
+		CloudProvider          string                 `json:"cloudProvider"`
+		KubernetesVersion      string                 `json:"kubernetesVersion"`
+		NetworkCIDR            string                 `json:"networkCIDR"`
+		AdditionalNetworkCIDRs []string               `json:"additionalNetworkCIDRs"`
+		NonMasqueradeCIDR      string                 `json:"nonMasqueradeCIDR"`
+		KubernetesAPIAccess    []string               `json:"kubernetesApiAccess"`
+		SSHAccess              []string               `json:"sshAccess"`
+		Networking             map[string]interface{} `json:"networking"`
+		Subnets                []struct {
+			Name string `json:"name"`
+			CIDR string `json:"cidr"`
+			Zone string `json:"zone"`
+			Type string `json:"type"`
+		} `json:"subnets"`
+		EtcdClusters []struct {
+			Name        string `json:"name"`
+			EtcdMembers []struct {
+				Name          string  `json:"name"`
+				InstanceGroup *string `json:"instanceGroup"`
+			} `json:"etcdMembers"`
+		} `json:"etcdClusters"`
+	} `json:"spec"`
+}
+
+type kopsInstanceGroup struct {
+	APIVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   kopsMetadata `json:"metadata"`
+	Spec       struct {
+		Role           string            `json:"role"`
+		MachineType    string            `json:"machineType"`
+		Image          string            `json:"image"`
+		MinSize        *int32            `json:"minSize"`
+		MaxSize        *int32            `json:"maxSize"`
+		RootVolumeSize *int32            `json:"rootVolumeSize"`
+		RootVolumeType string            `json:"rootVolumeType"`
+		Subnets        []string          `json:"subnets"`
+		Zones          []string          `json:"zones"`
+		NodeLabels     map[string]string `json:"nodeLabels"`
+		Taints         []string          `json:"taints"`
+	} `json:"spec"`
+}
+
+// SpecError is returned when a rendered cluster file is not a valid kops spec
+type SpecError struct {
+	// File is the jsonnet cluster file the spec was rendered from
+	File   string
+	Errors field.ErrorList
+	// Sources holds the jsonnet file and line, as file:line, where the field
+	// of each error is most likely set, or "" if it was not found
+	Sources []string
+}
+
+func (e *SpecError) Error() string {
+	lines := make([]string, 0, len(e.Errors))
+	for i, err := range e.Errors {
+		if i < len(e.Sources) && e.Sources[i] != "" {
+			lines = append(lines, fmt.Sprintf("  %v: %v", e.Sources[i], err))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %v", err))
+		}
+	}
+	return fmt.Sprintf("%v errors in cluster spec rendered from %v:\n%v", len(e.Errors), e.File, strings.Join(lines, "\n"))
+}
+
+// ValidateSpec renders the cluster file and validates the kops cluster and
+// instance group specs without contacting the state store.
+func ValidateSpec(ctx context.Context, file string) error {
+	cluster, _, err := jsonnet.ExpandCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	if err := validateSpec(file, cluster); err != nil {
+		return err
+	}
+	logrus.Infof("Cluster %v (%v) is valid.", cluster.Name, file)
+	return nil
+}
+
+// validateSpec checks the rendered cluster and instance groups for mistakes
+// that would otherwise fail halfway through an apply: missing fields kops
+// cannot default, malformed CIDRs and versions, and references to subnets
+// that are not declared. Values kops may accept, such as cloud providers or
+// roles, are left for kops to validate. Findings kops may still accept, e.g.
+// because the file does not hold all instance groups, are logged as warnings.
+func validateSpec(file string, cluster *types.Cluster) error {
+	errs, warnings := checkSpec(cluster)
+	for i, source := range specSources(file, cluster, warnings) {
+		if source != "" {
+			logrus.Warnf("%v: %v", source, warnings[i])
+		} else {
+			logrus.Warn(warnings[i])
+		}
+	}
+	if len(errs) > 0 {
+		return &SpecError{File: file, Errors: errs, Sources: specSources(file, cluster, errs)}
+	}
+	return nil
+}
+
+// checkSpec returns the errors and warnings of the rendered cluster and
+// instance groups, see validateSpec
+func checkSpec(cluster *types.Cluster) (field.ErrorList, field.ErrorList) {
+	errs, warnings := field.ErrorList{}, field.ErrorList{}
+	if cluster.Kops == nil {
+		errs = append(errs, field.Required(field.NewPath("Kops"), "kops configuration is missing"))
+		return errs, warnings
+	}
+
+	cPath := field.NewPath("Kops", "Cluster")
+	c := &kopsCluster{}
+	if err := decodeSpec(cluster.Kops.Cluster, c); err != nil {
+		errs = append(errs, field.Invalid(cPath, nil, err.Error()))
+		return errs, warnings
+	}
+	cErrs, cWarnings := validateKopsCluster(cPath, cluster.Name, c)
+	errs, warnings = append(errs, cErrs...), append(warnings, cWarnings...)
+
+	subnets := map[string]bool{}
+	for _, s := range c.Spec.Subnets {
+		subnets[s.Name] = true
+	}
+	igs := map[string]bool{}
+	masters := 0
+	for i, ig := range cluster.Kops.InstanceGroups {
+		igPath := field.NewPath("Kops", "InstanceGroups").Index(i)
+		if ig.Name == "" {
+			errs = append(errs, field.Required(igPath.Child("Name"), ""))
+		} else if igs[ig.Name] {
+			errs = append(errs, field.Duplicate(igPath.Child("Name"), ig.Name))
+		}
+		igs[ig.Name] = true
+
+		g := &kopsInstanceGroup{}
+		vPath := igPath.Child("Value")
+		if err := decodeSpec(ig.Value, g); err != nil {
+			errs = append(errs, field.Invalid(vPath, nil, err.Error()))
+			continue
+		}
+		errs = append(errs, validateKopsInstanceGroup(vPath, cluster.Name, ig.Name, g, subnets)...)
+		if g.Spec.Role == "Master" || g.Spec.Role == "ControlPlane" {
+			masters++
+		}
+	}
+	if masters == 0 {
+		warnings = append(warnings, field.Required(field.NewPath("Kops", "InstanceGroups"), "no Master or ControlPlane instance group in the cluster file"))
+	}
+
+	for i, etcd := range c.Spec.EtcdClusters {
+		for j, m := range etcd.EtcdMembers {
+			if m.InstanceGroup != nil && *m.InstanceGroup != "" && !igs[*m.InstanceGroup] {
+				p := cPath.Child("spec", "etcdClusters").Index(i).Child("etcdMembers").Index(j).Child("instanceGroup")
+				warnings = append(warnings, field.NotFound(p, *m.InstanceGroup))
+			}
+		}
+	}
+	return errs, warnings
+}
+
+// decodeSpec converts a rendered map into a typed kops object
+func decodeSpec(in map[string]interface{}, out interface{}) error {
+	if in == nil {
+		return fmt.Errorf("spec is missing")
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func validateKopsCluster(p *field.Path, name string, c *kopsCluster) (field.ErrorList, field.ErrorList) {
+	errs := validateTypeMeta(p, c.APIVersion, c.Kind, "Cluster")
+	warnings := field.ErrorList{}
+	mPath := p.Child("metadata", "name")
+	if c.Metadata.Name == "" {
+		errs = append(errs, field.Required(mPath, ""))
+	} else if c.Metadata.Name != name {
+		errs = append(errs, field.Invalid(mPath, c.Metadata.Name, fmt.Sprintf("must match cluster name %v", name)))
+	}
+
+	spec := p.Child("spec")
+	if c.Spec.CloudProvider == "" {
+		errs = append(errs, field.Required(spec.Child("cloudProvider"), ""))
+	}
+	if c.Spec.KubernetesVersion == "" {
+		warnings = append(warnings, field.Required(spec.Child("kubernetesVersion"), "kops uses the version of its channel"))
+	} else if _, err := semver.ParseTolerant(c.Spec.KubernetesVersion); err != nil && !strings.HasPrefix(c.Spec.KubernetesVersion, "http") {
+		errs = append(errs, field.Invalid(spec.Child("kubernetesVersion"), c.Spec.KubernetesVersion, err.Error()))
+	}
+
+	// Subnets must lie in the network or one of its additional CIDRs
+	networks := []*net.IPNet{}
+	if c.Spec.NetworkCIDR != "" {
+		if _, network, err := net.ParseCIDR(c.Spec.NetworkCIDR); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("networkCIDR"), c.Spec.NetworkCIDR, "must be a valid CIDR"))
+		} else {
+			networks = append(networks, network)
+		}
+		for i, cidr := range c.Spec.AdditionalNetworkCIDRs {
+			if _, network, err := net.ParseCIDR(cidr); err != nil {
+				errs = append(errs, field.Invalid(spec.Child("additionalNetworkCIDRs").Index(i), cidr, "must be a valid CIDR"))
+			} else {
+				networks = append(networks, network)
+			}
+		}
+	}
+	if c.Spec.NonMasqueradeCIDR != "" {
+		if _, _, err := net.ParseCIDR(c.Spec.NonMasqueradeCIDR); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("nonMasqueradeCIDR"), c.Spec.NonMasqueradeCIDR, "must be a valid CIDR"))
+		}
+	}
+	for i, cidr := range c.Spec.KubernetesAPIAccess {
+		errs = append(errs, validateCIDR(spec.Child("kubernetesApiAccess").Index(i), cidr)...)
+	}
+	for i, cidr := range c.Spec.SSHAccess {
+		errs = append(errs, validateCIDR(spec.Child("sshAccess").Index(i), cidr)...)
+	}
+
+	// kops defaults to kubenet without a networking provider
+	if len(c.Spec.Networking) > 1 {
+		warnings = append(warnings, field.Invalid(spec.Child("networking"), len(c.Spec.Networking), "configures more than one networking provider"))
+	}
+
+	if len(c.Spec.Subnets) == 0 {
+		errs = append(errs, field.Required(spec.Child("subnets"), "must configure at least one subnet"))
+	}
+	names := map[string]bool{}
+	cidrs := []*net.IPNet{}
+	for i, s := range c.Spec.Subnets {
+		sPath := spec.Child("subnets").Index(i)
+		if s.Name == "" {
+			errs = append(errs, field.Required(sPath.Child("name"), ""))
+		} else if names[s.Name] {
+			errs = append(errs, field.Duplicate(sPath.Child("name"), s.Name))
+		}
+		names[s.Name] = true
+		if s.Zone == "" {
+			warnings = append(warnings, field.Required(sPath.Child("zone"), ""))
+		}
+		if s.Type == "" {
+			warnings = append(warnings, field.Required(sPath.Child("type"), ""))
+		}
+		if s.CIDR == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(s.CIDR)
+		if err != nil {
+			errs = append(errs, field.Invalid(sPath.Child("cidr"), s.CIDR, "must be a valid CIDR"))
+			continue
+		}
+		if len(networks) > 0 && !anyCIDRContains(networks, subnet) {
+			errs = append(errs, field.Invalid(sPath.Child("cidr"), s.CIDR, "must be within networkCIDR or additionalNetworkCIDRs"))
+		}
+		for _, other := range cidrs {
+			if cidrContains(other, subnet) || cidrContains(subnet, other) {
+				warnings = append(warnings, field.Invalid(sPath.Child("cidr"), s.CIDR, fmt.Sprintf("overlaps %v", other)))
+			}
+		}
+		cidrs = append(cidrs, subnet)
+	}
+
+	if len(c.Spec.EtcdClusters) == 0 {
+		errs = append(errs, field.Required(spec.Child("etcdClusters"), "must configure at least one etcd cluster"))
+	}
+	etcdNames := map[string]bool{}
+	for i, etcd := range c.Spec.EtcdClusters {
+		ePath := spec.Child("etcdClusters").Index(i)
+		if etcd.Name == "" {
+			errs = append(errs, field.Required(ePath.Child("name"), ""))
+		} else if etcdNames[etcd.Name] {
+			errs = append(errs, field.Duplicate(ePath.Child("name"), etcd.Name))
+		}
+		etcdNames[etcd.Name] = true
+		if len(etcd.EtcdMembers) == 0 {
+			errs = append(errs, field.Required(ePath.Child("etcdMembers"), "must have at least one member"))
+		}
+		for j, m := range etcd.EtcdMembers {
+			mPath := ePath.Child("etcdMembers").Index(j)
+			if m.Name == "" {
+				errs = append(errs, field.Required(mPath.Child("name"), ""))
+			}
+			if m.InstanceGroup == nil || *m.InstanceGroup == "" {
+				errs = append(errs, field.Required(mPath.Child("instanceGroup"), ""))
+			}
+		}
+	}
+	return errs, warnings
+}
+
+func validateKopsInstanceGroup(p *field.Path, clusterName, name string, g *kopsInstanceGroup, subnets map[string]bool) field.ErrorList {
+	errs := validateTypeMeta(p, g.APIVersion, g.Kind, "InstanceGroup")
+	if g.Metadata.Name != name {
+		errs = append(errs, field.Invalid(p.Child("metadata", "name"), g.Metadata.Name, fmt.Sprintf("must match instance group name %v", name)))
+	}
+	if l, ok := g.Metadata.Labels["kops.k8s.io/cluster"]; ok && l != clusterName {
+		errs = append(errs, field.Invalid(p.Child("metadata", "labels").Key("kops.k8s.io/cluster"), l, fmt.Sprintf("must match cluster name %v", clusterName)))
+	}
+
+	spec := p.Child("spec")
+	if g.Spec.Role == "" {
+		errs = append(errs, field.Required(spec.Child("role"), ""))
+	}
+	if g.Spec.MinSize != nil && *g.Spec.MinSize < 0 {
+		errs = append(errs, field.Invalid(spec.Child("minSize"), *g.Spec.MinSize, "must not be negative"))
+	}
+	if g.Spec.MaxSize != nil && *g.Spec.MaxSize < 0 {
+		errs = append(errs, field.Invalid(spec.Child("maxSize"), *g.Spec.MaxSize, "must not be negative"))
+	}
+	if g.Spec.MinSize != nil && g.Spec.MaxSize != nil && *g.Spec.MinSize > *g.Spec.MaxSize {
+		errs = append(errs, field.Invalid(spec.Child("maxSize"), *g.Spec.MaxSize, "must be greater than or equal to minSize"))
+	}
+	if g.Spec.RootVolumeSize != nil && *g.Spec.RootVolumeSize <= 0 {
+		errs = append(errs, field.Invalid(spec.Child("rootVolumeSize"), *g.Spec.RootVolumeSize, "must be positive"))
+	}
+	for i, s := range g.Spec.Subnets {
+		if !subnets[s] {
+			errs = append(errs, field.NotFound(spec.Child("subnets").Index(i), s))
+		}
+	}
+	return errs
+}
+
+func validateTypeMeta(p *field.Path, apiVersion, kind, wantKind string) field.ErrorList {
+	errs := field.ErrorList{}
+	if apiVersion == "" {
+		errs = append(errs, field.Required(p.Child("apiVersion"), ""))
+	}
+	if kind != wantKind {
+		errs = append(errs, field.NotSupported(p.Child("kind"), kind, []string{wantKind}))
+	}
+	return errs
+}
+
+func validateCIDR(p *field.Path, cidr string) field.ErrorList {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return field.ErrorList{field.Invalid(p, cidr, "must be a valid CIDR")}
+	}
+	return nil
+}
+
+// anyCIDRContains reports whether inner lies entirely within one of outers
+func anyCIDRContains(outers []*net.IPNet, inner *net.IPNet) bool {
+	for _, outer := range outers {
+		if cidrContains(outer, inner) {
+			return true
+		}
+	}
+	return false
+}
+
+// cidrContains reports whether inner lies entirely within outer
+func cidrContains(outer, inner *net.IPNet) bool {
+	outerOnes, _ := outer.Mask.Size()
+	innerOnes, _ := inner.Mask.Size()
+	return outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// jsonnetImportRe matches the files imported by a jsonnet file
+var jsonnetImportRe = regexp.MustCompile(`import(?:str)?\s+['"]([^'"]+)['"]`)
+
+// fieldNameRe matches the field names in a field path, skipping indexes and keys
+var fieldNameRe = regexp.MustCompile(`(?:^|\.)([A-Za-z0-9_]+)`)
+
+// igIndexRe matches the instance group of a field path
+var igIndexRe = regexp.MustCompile(`^Kops\.InstanceGroups\[(\d+)\]`)
+
+type jsonnetSource struct {
+	path  string
+	lines []string
+}
+
+// specSources locates the jsonnet source of each error in the cluster file
+// and the files it imports. The source is the first line setting the last
+// field of the error's path; for instance groups, lines after the first
+// mention of the instance group's name are preferred.
+func specSources(file string, cluster *types.Cluster, errs field.ErrorList) []string {
+	sources := jsonnetSources(file)
+	locations := make([]string, len(errs))
+	for i, e := range errs {
+		names := fieldNameRe.FindAllStringSubmatch(e.Field, -1)
+		if len(names) == 0 {
+			continue
+		}
+		key := names[len(names)-1][1]
+		ig := ""
+		if m := igIndexRe.FindStringSubmatch(e.Field); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil && n < len(cluster.Kops.InstanceGroups) {
+				ig = cluster.Kops.InstanceGroups[n].Name
+			}
+		}
+		locations[i] = locateField(sources, key, ig)
+	}
+	return locations
+}
+
+// locateField returns file:line of the first line setting key, preferring
+// lines after a mention of name if it is set
+func locateField(sources []jsonnetSource, key, name string) string {
+	keyRe := regexp.MustCompile(`(?:^|[\s{,])['"]?` + regexp.QuoteMeta(key) + `['"]?\s*\+?:`)
+	if name != "" {
+		nameRe := regexp.MustCompile(`['"]` + regexp.QuoteMeta(name) + `['"]`)
+		for _, src := range sources {
+			for i, line := range src.lines {
+				if !nameRe.MatchString(line) {
+					continue
+				}
+				for j := i; j < len(src.lines); j++ {
+					if keyRe.MatchString(src.lines[j]) {
+						return fmt.Sprintf("%v:%v", src.path, j+1)
+					}
+				}
+				break
+			}
+		}
+	}
+	for _, src := range sources {
+		for i, line := range src.lines {
+			if keyRe.MatchString(line) {
+				return fmt.Sprintf("%v:%v", src.path, i+1)
+			}
+		}
+	}
+	return ""
+}
+
+// jsonnetSources reads the cluster file and the local files it imports.
+// Imports are resolved relative to the importing file, then to the workspace.
+func jsonnetSources(file string) []jsonnetSource {
+	ctxDir := ""
+	if abs, err := filepath.Abs(file); err == nil {
+		ctxDir, _ = util.GetContextDir(abs)
+	}
+	sources := []jsonnetSource{}
+	seen := map[string]bool{}
+	var read func(path string)
+	read = func(path string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return
+		}
+		sources = append(sources, jsonnetSource{path: path, lines: strings.Split(string(b), "\n")})
+		for _, m := range jsonnetImportRe.FindAllStringSubmatch(string(b), -1) {
+			imported := filepath.Join(filepath.Dir(path), m[1])
+			if _, err := os.Stat(imported); err != nil && ctxDir != "" {
+				imported = filepath.Join(ctxDir, m[1])
+			}
+			read(imported)
+		}
+	}
+	read(file)
+	return sources
+}
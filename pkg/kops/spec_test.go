@@ -0,0 +1,252 @@
+package kops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func specCluster(t *testing.T, cluster, master, nodes string) *types.Cluster {
+	decode := func(s string) map[string]interface{} {
+		m := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	return &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{
+			Cluster: decode(cluster),
+			InstanceGroups: []types.InstanceGroup{
+				{Name: "master-a", Value: decode(master)},
+				{Name: "nodes", Value: decode(nodes)},
+			},
+		},
+	}
+}
+
+const validSpecCluster = `{
+	"apiVersion": "kops/v1alpha2", "kind": "Cluster",
+	"metadata": {"name": "test.k8s.local"},
+	"spec": {
+		"cloudProvider": "aws",
+		"kubernetesVersion": "1.12.7",
+		"networkCIDR": "10.0.0.0/16",
+		"networking": {"calico": {}},
+		"subnets": [
+			{"name": "us-west-2a", "zone": "us-west-2a", "cidr": "10.0.0.0/19", "type": "Private"},
+			{"name": "utility-us-west-2a", "zone": "us-west-2a", "cidr": "10.0.32.0/22", "type": "Utility"}
+		],
+		"etcdClusters": [
+			{"name": "main", "etcdMembers": [{"name": "a", "instanceGroup": "master-a"}]}
+		]
+	}
+}`
+
+const validSpecMaster = `{
+	"apiVersion": "kops/v1alpha2", "kind": "InstanceGroup",
+	"metadata": {"name": "master-a", "labels": {"kops.k8s.io/cluster": "test.k8s.local"}},
+	"spec": {"role": "Master", "minSize": 1, "maxSize": 1, "subnets": ["us-west-2a"]}
+}`
+
+func TestValidateSpec(t *testing.T) {
+	nodes := `{
+		"apiVersion": "kops/v1alpha2", "kind": "InstanceGroup",
+		"metadata": {"name": "nodes"},
+		"spec": {"role": "Node", "minSize": 2, "maxSize": 5, "subnets": ["us-west-2a"]}
+	}`
+	if err := validateSpec("cluster.jsonnet", specCluster(t, validSpecCluster, validSpecMaster, nodes)); err != nil {
+		t.Fatalf("expected valid spec, got %v", err)
+	}
+
+	// Values newer kops versions accept are left to kops
+	nodes = `{
+		"apiVersion": "kops.k8s.io/v1alpha2", "kind": "InstanceGroup",
+		"metadata": {"name": "nodes"},
+		"spec": {"role": "APIServer", "minSize": 2, "maxSize": 5, "subnets": ["us-west-2a"]}
+	}`
+	if err := validateSpec("cluster.jsonnet", specCluster(t, validSpecCluster, validSpecMaster, nodes)); err != nil {
+		t.Fatalf("expected valid spec, got %v", err)
+	}
+
+	nodes = `{
+		"apiVersion": "kops/v1alpha2", "kind": "InstanceGroup",
+		"metadata": {"name": "nodes"},
+		"spec": {"role": "", "minSize": 5, "maxSize": 2, "subnets": ["us-west-2b"]}
+	}`
+	err := validateSpec("cluster.jsonnet", specCluster(t, validSpecCluster, validSpecMaster, nodes))
+	specErr, ok := err.(*SpecError)
+	if !ok {
+		t.Fatalf("expected SpecError, got %v", err)
+	}
+	if specErr.File != "cluster.jsonnet" {
+		t.Errorf("got file %v", specErr.File)
+	}
+	paths := errorPaths(specErr.Errors)
+	want := []string{
+		"Kops.InstanceGroups[1].Value.spec.maxSize",
+		"Kops.InstanceGroups[1].Value.spec.role",
+		"Kops.InstanceGroups[1].Value.spec.subnets[0]",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %q, want %q", paths, want)
+	}
+}
+
+func TestValidateSpecCluster(t *testing.T) {
+	cluster := `{
+		"apiVersion": "kops/v1alpha2", "kind": "Cluster",
+		"metadata": {"name": "other.k8s.local"},
+		"spec": {
+			"cloudProvider": "aws",
+			"kubernetesVersion": "1.12",
+			"networkCIDR": "10.0.0.0/16",
+			"networking": {"calico": {}},
+			"subnets": [
+				{"name": "us-west-2a", "zone": "us-west-2a", "cidr": "10.1.0.0/19", "type": "Private"},
+				{"name": "us-west-2a", "zone": "us-west-2a", "cidr": "10.0.0.0/19", "type": "Private"}
+			],
+			"etcdClusters": [
+				{"name": "main", "etcdMembers": [{"name": "a", "instanceGroup": "master-b"}]}
+			]
+		}
+	}`
+	errs, warnings := checkSpec(specCluster(t, cluster, validSpecMaster, validSpecMaster))
+	want := []string{
+		"Kops.Cluster.metadata.name",
+		"Kops.Cluster.spec.subnets[0].cidr",
+		"Kops.Cluster.spec.subnets[1].name",
+		"Kops.InstanceGroups[1].Value.metadata.name",
+	}
+	if paths := errorPaths(errs); !reflect.DeepEqual(paths, want) {
+		t.Errorf("got errors %q, want %q", paths, want)
+	}
+	// The instance group may be managed in another file
+	want = []string{"Kops.Cluster.spec.etcdClusters[0].etcdMembers[0].instanceGroup"}
+	if paths := errorPaths(warnings); !reflect.DeepEqual(paths, want) {
+		t.Errorf("got warnings %q, want %q", paths, want)
+	}
+}
+
+func TestValidateSpecDefaults(t *testing.T) {
+	// Subnets in additional network CIDRs, kops' default networking, instance
+	// groups without subnets and ControlPlane roles are all accepted
+	cluster := `{
+		"apiVersion": "kops/v1alpha2", "kind": "Cluster",
+		"metadata": {"name": "test.k8s.local"},
+		"spec": {
+			"cloudProvider": "aws",
+			"kubernetesVersion": "1.12.7",
+			"networkCIDR": "10.0.0.0/16",
+			"additionalNetworkCIDRs": ["10.1.0.0/16"],
+			"subnets": [
+				{"name": "us-west-2a", "zone": "us-west-2a", "cidr": "10.0.0.0/19", "type": "Private"},
+				{"name": "us-west-2b", "zone": "us-west-2b", "cidr": "10.1.0.0/19", "type": "Private"}
+			],
+			"etcdClusters": [
+				{"name": "main", "etcdMembers": [{"name": "a", "instanceGroup": "master-a"}]}
+			]
+		}
+	}`
+	master := `{
+		"apiVersion": "kops.k8s.io/v1alpha2", "kind": "InstanceGroup",
+		"metadata": {"name": "master-a"},
+		"spec": {"role": "ControlPlane", "minSize": 1, "maxSize": 1}
+	}`
+	nodes := `{
+		"apiVersion": "kops.k8s.io/v1alpha2", "kind": "InstanceGroup",
+		"metadata": {"name": "nodes"},
+		"spec": {"role": "Node", "minSize": 1, "maxSize": 1, "subnets": ["us-west-2b"]}
+	}`
+	errs, warnings := checkSpec(specCluster(t, cluster, master, nodes))
+	if len(errs) > 0 || len(warnings) > 0 {
+		t.Errorf("expected no findings, got errors %v and warnings %v", errs, warnings)
+	}
+
+	// Files holding only some instance groups get a warning
+	c := specCluster(t, cluster, nodes, nodes)
+	c.Kops.InstanceGroups = c.Kops.InstanceGroups[1:]
+	errs, warnings = checkSpec(c)
+	want := []string{"Kops.Cluster.spec.etcdClusters[0].etcdMembers[0].instanceGroup", "Kops.InstanceGroups"}
+	if paths := errorPaths(warnings); len(errs) > 0 || !reflect.DeepEqual(paths, want) {
+		t.Errorf("got errors %v and warnings %q, want warnings %q", errs, paths, want)
+	}
+
+	// Subnets outside all network CIDRs are still errors
+	cluster = strings.Replace(cluster, "10.1.0.0/19", "10.2.0.0/19", 1)
+	errs, _ = checkSpec(specCluster(t, cluster, master, nodes))
+	if paths := errorPaths(errs); !reflect.DeepEqual(paths, []string{"Kops.Cluster.spec.subnets[1].cidr"}) {
+		t.Errorf("unexpected errors %q", paths)
+	}
+}
+
+func errorPaths(errs field.ErrorList) []string {
+	paths := []string{}
+	for _, e := range errs {
+		paths = append(paths, e.Field)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestSpecErrorSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wk-spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cluster.jsonnet")
+	lib := filepath.Join(dir, "lib", "igs.libsonnet")
+	os.Mkdir(filepath.Dir(lib), 0755)
+	if err := ioutil.WriteFile(file, []byte(`local igs = import 'lib/igs.libsonnet';
+{
+  kops: {
+    cluster: { spec: { subnets: [] } },
+    instanceGroups: igs,
+  },
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(lib, []byte(`[
+  kops.instanceGroup('master-a') { value+: { spec: { role: 'Master', maxSize: 1 } } },
+  kops.instanceGroup('nodes') {
+    value+: {
+      spec: {
+        role: 'Node',
+        maxSize: 2,
+      },
+    },
+  },
+]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := `{
+		"apiVersion": "kops/v1alpha2", "kind": "InstanceGroup",
+		"metadata": {"name": "nodes"},
+		"spec": {"role": "Node", "minSize": 5, "maxSize": 2, "subnets": ["us-west-2a"]}
+	}`
+	err = validateSpec(file, specCluster(t, validSpecCluster, validSpecMaster, nodes))
+	specErr, ok := err.(*SpecError)
+	if !ok || len(specErr.Errors) != 1 {
+		t.Fatalf("expected a SpecError, got %v", err)
+	}
+	if want := lib + ":7"; specErr.Sources[0] != want {
+		t.Errorf("got source %q, want %q", specErr.Sources[0], want)
+	}
+	if !strings.Contains(err.Error(), lib+":7: Kops.InstanceGroups[1].Value.spec.maxSize") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
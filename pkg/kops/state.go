@@ -1,10 +1,14 @@
 package kops
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+
+	"github.com/wish/wk/pkg/specdiff"
 	// "bitbucket.org/avd/go-ipc/sync"
 )
 
@@ -19,6 +23,9 @@ type State struct {
 type ObjectState struct {
 	UpdateRequired bool
 	DiffText       string
+	Changes        []specdiff.Change
+	// Version identifies the object in the state store the diff was made against
+	Version string
 }
 
 func (s *State) requiresUpdate() bool {
@@ -38,8 +45,13 @@ func (s *State) renderDiffs() string {
 	if s.Cluster.DiffText != "" {
 		r += fmt.Sprintf("Cluster changed:\n%v\n\n", s.Cluster.DiffText)
 	}
-	for name, ig := range s.InstanceGroups {
-		if ig.DiffText != "" {
+	names := make([]string, 0, len(s.InstanceGroups))
+	for name := range s.InstanceGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ig := s.InstanceGroups[name]; ig.DiffText != "" {
 			r += fmt.Sprintf("Instance Group %v changed:\n%v\n\n", name, ig.DiffText)
 		}
 	}
@@ -49,6 +61,41 @@ func (s *State) renderDiffs() string {
 	return r
 }
 
+// StateDiff is the JSON document form of the changes recorded in a State
+type StateDiff struct {
+	Cluster        []specdiff.Change            `json:"cluster"`
+	InstanceGroups map[string][]specdiff.Change `json:"instanceGroups"`
+}
+
+func (s *State) diffDocument() StateDiff {
+	d := StateDiff{
+		Cluster:        s.Cluster.Changes,
+		InstanceGroups: make(map[string][]specdiff.Change),
+	}
+	if d.Cluster == nil {
+		d.Cluster = []specdiff.Change{}
+	}
+	for name, ig := range s.InstanceGroups {
+		if len(ig.Changes) > 0 {
+			d.InstanceGroups[name] = ig.Changes
+		}
+	}
+	return d
+}
+
+func (s *State) writeDiffs(path string) error {
+	b, err := json.MarshalIndent(s.diffDocument(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// objectVersion hashes an object as read from the state store
+func objectVersion(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
 func newState() *State {
 	return &State{
 		InstanceGroups: make(map[string]ObjectState),
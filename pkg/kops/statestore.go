@@ -0,0 +1,96 @@
+package kops
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"k8s.io/kops/util/pkg/vfs"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+// wkDir is the directory in the state store holding wk's own files, one
+// directory per cluster. It is kept out of the directories of the clusters,
+// which kops refuses to delete while they hold files it does not know. kops
+// cluster names always contain a dot, so no cluster is named like it.
+const wkDir = "wk"
+
+// clusterGetenv looks up variables in the cluster's kops environment, falling
+// back to the environment of wk like kops does when run for the cluster
+func clusterGetenv(cluster *types.Cluster) func(string) string {
+	return func(k string) string {
+		if v, ok := cluster.Kops.Env[k]; ok {
+			return v
+		}
+		return os.Getenv(k)
+	}
+}
+
+// storePath opens location, a kops state store URL, for the cluster. S3 stores
+// get a client configured from the cluster's kops environment. Other stores
+// are opened through the shared vfs context of kops, which reads the
+// environment of wk.
+func storePath(cluster *types.Cluster, location string) (vfs.Path, error) {
+	if strings.HasPrefix(location, "s3://") {
+		bucket := strings.TrimPrefix(location, "s3://")
+		key := ""
+		if i := strings.Index(bucket, "/"); i >= 0 {
+			bucket, key = bucket[:i], strings.Trim(bucket[i+1:], "/")
+		}
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid S3 location %v", location)
+		}
+		return &s3StorePath{store: clusterS3Store(cluster, clusterGetenv(cluster), bucket), key: key}, nil
+	}
+	return vfs.Context.BuildVfsPath(location)
+}
+
+// stateStorePath returns the path of wk's files for the cluster in the kops state store
+func stateStorePath(cluster *types.Cluster) (vfs.Path, error) {
+	p, err := storeRootPath(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return p.Join(wkDir, cluster.Name), nil
+}
+
+// clusterStorePath returns the path of the cluster in the kops state store
+func clusterStorePath(cluster *types.Cluster) (vfs.Path, error) {
+	p, err := storeRootPath(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return p.Join(cluster.Name), nil
+}
+
+// storeRootPath returns the path of the kops state store of the cluster
+func storeRootPath(cluster *types.Cluster) (vfs.Path, error) {
+	store := clusterGetenv(cluster)("KOPS_STATE_STORE")
+	if store == "" {
+		return nil, fmt.Errorf("KOPS_STATE_STORE is not set for cluster %v", cluster.Name)
+	}
+	p, err := storePath(cluster, store)
+	if err != nil {
+		return nil, fmt.Errorf("could not open state store %v: %v", store, err)
+	}
+	return p, nil
+}
+
+// currentUser returns the name of the user running wk
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// currentHost returns the host name wk runs on
+func currentHost() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return h
+}
@@ -0,0 +1,41 @@
+package kops
+
+import (
+	"os"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestClusterStorePath(t *testing.T) {
+	os.Unsetenv("KOPS_STATE_STORE")
+	a := &types.Cluster{Name: "a.k8s.local", Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "s3://store-a/clusters"}}}
+	b := &types.Cluster{Name: "b.k8s.local", Kops: &types.Kops{Env: map[string]string{"KOPS_STATE_STORE": "s3://store-b"}}}
+	c := &types.Cluster{Name: "c.k8s.local", Kops: &types.Kops{}}
+
+	pa, err := stateStorePath(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := stateStorePath(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pa.Join("lock.json").Path(), "s3://store-a/clusters/wk/a.k8s.local/lock.json"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := pb.Path(), "s3://store-b/wk/b.k8s.local"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if pa.(*s3StorePath).store == pb.(*s3StorePath).store {
+		t.Error("expected clusters not to share a store client")
+	}
+
+	// The environment of one cluster is never carried over to the next
+	if v := os.Getenv("KOPS_STATE_STORE"); v != "" {
+		t.Errorf("process environment changed: KOPS_STATE_STORE=%v", v)
+	}
+	if _, err := stateStorePath(c); err == nil {
+		t.Error("expected cluster without state store to fail")
+	}
+}
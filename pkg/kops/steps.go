@@ -0,0 +1,129 @@
+package kops
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+
+	"github.com/wish/wk/pkg/util"
+)
+
+// StepTimeouts bounds the steps of a cluster apply. A zero timeout means no
+// limit. Validation is bounded by ClusterApplyOptions.ValidateTimeout.
+type StepTimeouts struct {
+	Render      time.Duration
+	EditCluster time.Duration
+	// EditIG bounds the edit of each instance group
+	EditIG time.Duration
+	Update time.Duration
+}
+
+// StepError is returned when a step was interrupted or timed out
+type StepError struct {
+	Step string
+	// Timeout is set if the step timed out
+	Timeout time.Duration
+	// Running is set if the step was aborted while running rather than
+	// interrupted before it started
+	Running bool
+	Err     error
+}
+
+func (e *StepError) Error() string {
+	switch {
+	case e.Timeout > 0:
+		return fmt.Sprintf("step %v timed out after %v: %v", e.Step, e.Timeout, e.Err)
+	case e.Running:
+		return fmt.Sprintf("step %v aborted: %v", e.Step, e.Err)
+	}
+	return fmt.Sprintf("interrupted before step %v", e.Step)
+}
+
+// AddTimeoutOpts adds the step timeout flags to cmd
+func AddTimeoutOpts(cmd *cobra.Command) {
+	cmd.Flags().Duration("render-timeout", 5*time.Minute, "How long rendering the cluster file may take")
+	cmd.Flags().Duration("edit-cluster-timeout", 10*time.Minute, "How long editing the cluster spec may take")
+	cmd.Flags().Duration("edit-ig-timeout", 10*time.Minute, "How long editing each instance group may take")
+	cmd.Flags().Duration("update-timeout", 30*time.Minute, "How long kops update cluster may take")
+}
+
+// TimeoutsFromFlags reads step timeouts added by AddTimeoutOpts
+func TimeoutsFromFlags(flags *flag.FlagSet) (StepTimeouts, error) {
+	t := StepTimeouts{}
+	var err error
+	if t.Render, err = flags.GetDuration("render-timeout"); err != nil {
+		return t, err
+	}
+	if t.EditCluster, err = flags.GetDuration("edit-cluster-timeout"); err != nil {
+		return t, err
+	}
+	if t.EditIG, err = flags.GetDuration("edit-ig-timeout"); err != nil {
+		return t, err
+	}
+	if t.Update, err = flags.GetDuration("update-timeout"); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// runStep runs step name and records it in the report of opts. No step is
+// started once ctx is cancelled. The step itself runs with the abort context
+// of ctx, so an interrupt lets it finish, and each attempt is bounded by
+// timeout if set. Idempotent steps are retried according to opts.
+func runStep(ctx context.Context, opts ClusterApplyOptions, name string, timeout time.Duration, f func(ctx context.Context) error) error {
+	r := opts.report
+	if ctx.Err() != nil {
+		err := &StepError{Step: name, Err: ctx.Err()}
+		r.interrupted(name)
+		return err
+	}
+
+	policy := opts.retry[stepKind(name)]
+	start := time.Now()
+	attempt := 0
+	var err error
+	for {
+		attempt++
+		out := &outputTail{}
+		err = runAttempt(ctx, name, timeout, out, f)
+		if err == nil || policy == nil || attempt >= policy.attempts || !policy.retryable(err, out.String()) {
+			break
+		}
+		d := policy.delay(attempt)
+		logrus.Warnf("Step %v failed (attempt %v of %v), retrying in %v: %v", name, attempt, policy.attempts, d, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	r.record(name, time.Since(start), attempt, err)
+	return err
+}
+
+// runAttempt runs a single attempt of step name, recording its output in out
+func runAttempt(ctx context.Context, name string, timeout time.Duration, out *outputTail, f func(ctx context.Context) error) error {
+	stepCtx := context.WithValue(util.AbortContext(ctx), outputTailKey{}, out)
+	cancel := func() {}
+	if timeout > 0 {
+		stepCtx, cancel = context.WithTimeout(stepCtx, timeout)
+	}
+	defer cancel()
+
+	err := f(stepCtx)
+	if err != nil {
+		switch stepCtx.Err() {
+		case context.DeadlineExceeded:
+			err = &StepError{Step: name, Timeout: timeout, Running: true, Err: err}
+		case context.Canceled:
+			err = &StepError{Step: name, Running: true, Err: err}
+		}
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package kops
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunStepTimeout(t *testing.T) {
+	log, cleanup := stubKops(t, "sleep 5")
+	defer cleanup()
+
+	r := newReport("cluster.jsonnet", false)
+	start := time.Now()
+	err := runStep(context.Background(), ClusterApplyOptions{report: r}, "update", 100*time.Millisecond, func(ctx context.Context) error {
+		return kopsCommand(ctx, os.Environ(), "update", "cluster").Run()
+	})
+	if time.Since(start) > 3*time.Second {
+		t.Error("kops was not stopped at the timeout")
+	}
+	stepErr, ok := err.(*StepError)
+	if !ok || stepErr.Step != "update" || stepErr.Timeout != 100*time.Millisecond {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if r.Interrupted != "update" || len(r.Steps) != 1 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if calls := readCalls(t, log); len(calls) != 1 {
+		t.Errorf("unexpected calls %q", calls)
+	}
+}
+
+func TestRunStepInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := newReport("cluster.jsonnet", false)
+	called := false
+	err := runStep(ctx, ClusterApplyOptions{report: r}, "edit-cluster", 0, func(context.Context) error { called = true; return nil })
+	if called {
+		t.Error("step started after the interrupt")
+	}
+	if stepErr, ok := err.(*StepError); !ok || stepErr.Running || stepErr.Error() != "interrupted before step edit-cluster" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if r.Interrupted != "edit-cluster" || len(r.Steps) != 0 {
+		t.Errorf("unexpected report %+v", r)
+	}
+}
@@ -0,0 +1,215 @@
+package kops
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	linediff "github.com/kylelemons/godebug/diff"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+const (
+	// TargetDirect updates the cloud resources directly
+	TargetDirect = "direct"
+	// TargetTerraform writes terraform files instead of changing cloud resources
+	TargetTerraform = "terraform"
+)
+
+// clusterTarget returns the cluster's kops update target
+func clusterTarget(cluster *types.Cluster) (string, error) {
+	switch cluster.Kops.Target {
+	case "", TargetDirect:
+		return TargetDirect, nil
+	case TargetTerraform:
+		return TargetTerraform, nil
+	}
+	return "", fmt.Errorf("unsupported kops target %q for cluster %v", cluster.Kops.Target, cluster.Name)
+}
+
+// terraformDir returns the directory the cluster's terraform output is written
+// to. Relative paths are relative to the workspace; the default is
+// terraform/<cluster name>.
+func terraformDir(file string, cluster *types.Cluster) (string, error) {
+	dir := cluster.Kops.TerraformOut
+	if dir == "" {
+		dir = filepath.Join("terraform", cluster.Name)
+	}
+	if filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	ctxDir, err := util.GetContextDir(file)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ctxDir, dir), nil
+}
+
+// updateCluster runs `kops update cluster` against the cluster's target. With
+// the terraform target the files are written to the terraform directory and
+// their changes are printed; applying them is left to terraform.
+func updateCluster(ctx context.Context, file string, cluster *types.Cluster, env []string) error {
+	target, err := clusterTarget(cluster)
+	if err != nil {
+		return err
+	}
+	if target == TargetDirect {
+		uCmd := kopsCommand(ctx, env, "update", "cluster", "--name="+cluster.Name, "-v1", "--yes", "--create-kube-config=false")
+		uCmd.Stdout, uCmd.Stderr = stepOutput(ctx)
+		if err := uCmd.Run(); err != nil {
+			return fmt.Errorf("could not update cluster: %w", err)
+		}
+		return nil
+	}
+
+	dir, err := terraformDir(file, cluster)
+	if err != nil {
+		return err
+	}
+	d, err := writeTerraform(ctx, cluster, env, dir)
+	if err != nil {
+		return err
+	}
+	if d != "" {
+		fmt.Printf("Terraform output changed:\n%v\n\n", d)
+	} else {
+		logrus.Infoln("Terraform output unchanged.")
+	}
+	logrus.Infof("Terraform output written to %v. Apply it with terraform.", dir)
+	return nil
+}
+
+// previewTerraform prints the changes `kops update cluster` would make to the
+// terraform directory. kops writes into a temporary copy of the directory so
+// the diff matches the one of the real update. kops renders the spec in the
+// state store, so edits pending in this apply are not part of it.
+func previewTerraform(ctx context.Context, file string, cluster *types.Cluster, env []string) error {
+	dir, err := terraformDir(file, cluster)
+	if err != nil {
+		return err
+	}
+	current, err := readTree(dir)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir("", "wk-terraform")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := writeTree(tmp, current); err != nil {
+		return err
+	}
+	d, err := writeTerraform(ctx, cluster, env, tmp)
+	if err != nil {
+		return err
+	}
+	if d != "" {
+		fmt.Printf("Terraform output would change:\n%v\n\n", d)
+	} else {
+		logrus.Infoln("Terraform output would not change.")
+	}
+	return nil
+}
+
+// writeTerraform writes the cluster's terraform output to dir and returns the
+// diff of the directory's files
+func writeTerraform(ctx context.Context, cluster *types.Cluster, env []string, dir string) (string, error) {
+	before, err := readTree(dir)
+	if err != nil {
+		return "", err
+	}
+	uCmd := kopsCommand(ctx, env, "update", "cluster", "--name="+cluster.Name, "-v1", "--target=terraform", "--out="+dir, "--create-kube-config=false")
+	uCmd.Stdout, uCmd.Stderr = stepOutput(ctx)
+	if err := uCmd.Run(); err != nil {
+		return "", fmt.Errorf("could not write terraform output: %w", err)
+	}
+	after, err := readTree(dir)
+	if err != nil {
+		return "", err
+	}
+	return treeDiff(before, after), nil
+}
+
+// readTree reads all files below dir keyed by their relative path. A missing
+// dir is empty.
+func readTree(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			// Skip terraform's own state and plugins
+			if info.Name() == ".terraform" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = string(b)
+		return nil
+	})
+	return files, err
+}
+
+// writeTree writes files keyed by their path relative to dir
+func writeTree(dir string, files map[string]string) error {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeDiff renders the line diff of every file that differs between the trees
+func treeDiff(old, new map[string]string) string {
+	names := []string{}
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := []string{}
+	for _, name := range names {
+		o, inOld := old[name]
+		n, inNew := new[name]
+		switch {
+		case !inOld:
+			out = append(out, fmt.Sprintf("+++ %v (added)", name))
+		case !inNew:
+			out = append(out, fmt.Sprintf("--- %v (removed)", name))
+		case o != n:
+			chunks := linediff.DiffChunks(strings.Split(o, "\n"), strings.Split(n, "\n"))
+			out = append(out, fmt.Sprintf("~~~ %v\n%v", name, util.Render(chunks)))
+		}
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,98 @@
+package kops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestUpdateClusterTerraform(t *testing.T) {
+	log, cleanup := stubKops(t, `for a in "$@"; do
+	case $a in
+	--out=*) d=${a#--out=}; mkdir -p $d; echo 'resource "aws_vpc" "b" {}' > $d/kubernetes.tf;;
+	esac
+done`)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "wk-terraform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{Target: TargetTerraform, TerraformOut: out}}
+	if err := updateCluster(context.Background(), "", cluster, os.Environ()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"update cluster --name=test.k8s.local -v1 --target=terraform --out=" + out + " --create-kube-config=false"}
+	if calls := readCalls(t, log); !reflect.DeepEqual(calls, want) {
+		t.Errorf("got %q, want %q", calls, want)
+	}
+	files, err := readTree(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files["kubernetes.tf"] != "resource \"aws_vpc\" \"b\" {}\n" {
+		t.Errorf("unexpected terraform output %q", files)
+	}
+
+	cluster.Kops.Target = "cloudformation"
+	if err := updateCluster(context.Background(), "", cluster, os.Environ()); err == nil {
+		t.Errorf("expected error for unsupported target")
+	}
+}
+
+func TestPreviewTerraform(t *testing.T) {
+	// The stub changes the existing file, which fails if it wasn't copied
+	log, cleanup := stubKops(t, `for a in "$@"; do
+	case $a in
+	--out=*) d=${a#--out=}; grep -q '"a"' $d/kubernetes.tf && echo 'resource "aws_vpc" "b" {}' > $d/kubernetes.tf;;
+	esac
+done`)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "wk-terraform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	existing := map[string]string{"kubernetes.tf": "resource \"aws_vpc\" \"a\" {}\n"}
+	if err := writeTree(dir, existing); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{Target: TargetTerraform, TerraformOut: dir}}
+	if err := previewTerraform(context.Background(), "", cluster, os.Environ()); err != nil {
+		t.Fatal(err)
+	}
+	calls := readCalls(t, log)
+	if len(calls) != 1 || strings.Contains(calls[0], "--out="+dir+" ") {
+		t.Errorf("expected one update into a temporary directory, got %q", calls)
+	}
+	files, err := readTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, existing) {
+		t.Errorf("preview changed the terraform output: %q", files)
+	}
+}
+
+func TestTreeDiff(t *testing.T) {
+	old := map[string]string{"a.tf": "x\ny\n", "b.tf": "b", "data/c": "c"}
+	new := map[string]string{"a.tf": "x\nz\n", "data/c": "c", "d.tf": "d"}
+	want := "~~~ a.tf\n x\n-y\n+z\n \n--- b.tf (removed)\n+++ d.tf (added)"
+	if got := treeDiff(old, new); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := treeDiff(old, old); got != "" {
+		t.Errorf("expected no diff, got %q", got)
+	}
+}
@@ -0,0 +1,331 @@
+package kops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/wish/wk/pkg/jsonnet"
+	"github.com/wish/wk/pkg/types"
+	"github.com/wish/wk/pkg/util"
+)
+
+// defaultImageCompatibility is the image compatibility table file in the
+// workspace used unless the workspace configures another
+const defaultImageCompatibility = "image-compatibility.yaml"
+
+// UpgradeOptions configures a ClusterUpgrade run
+type UpgradeOptions struct {
+	// To is the Kubernetes version to upgrade to. It overrides the version
+	// set in the cluster file for this run; empty upgrades to that version.
+	To string
+	// Preview only runs the checks and shows the plan
+	Preview bool
+	// AutoApprove runs all steps without asking. Otherwise confirmation is
+	// read from In before each step.
+	AutoApprove bool
+	In          io.Reader
+	// RollingUpdate configures the rolling update of all instance groups
+	RollingUpdate RollingUpdateOptions
+	// ValidateTimeout bounds waiting for the cluster to validate at the end
+	ValidateTimeout time.Duration
+	// Timeouts bounds the steps of the run
+	Timeouts StepTimeouts
+}
+
+// upgradeSteps are the steps of an upgrade, each gated by a confirmation
+var upgradeSteps = []string{"apply", "update", "rolling-update", "validate"}
+
+// ClusterUpgrade upgrades the Kubernetes version of a cluster to opts.To, or
+// to the version set in the cluster file if opts.To is empty. The upgrade is
+// checked against the version skew rules, the image compatibility table and
+// the APIs used by the cluster's channels, then applied, updated, rolled and
+// validated in order, asking before each step.
+func ClusterUpgrade(ctx context.Context, file string, opts UpgradeOptions) error {
+	cluster, tfile, err := jsonnet.ExpandCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	if err := validateSpec(file, cluster); err != nil {
+		return err
+	}
+	c := &kopsCluster{}
+	if err := decodeSpec(cluster.Kops.Cluster, c); err != nil {
+		return fmt.Errorf("could not decode cluster: %v", err)
+	}
+	to := opts.To
+	if to == "" {
+		to = c.Spec.KubernetesVersion
+	}
+	target, err := semver.ParseTolerant(to)
+	if err != nil {
+		return fmt.Errorf("invalid Kubernetes version %q: %v", to, err)
+	}
+	if v, err := semver.ParseTolerant(c.Spec.KubernetesVersion); err != nil || !v.Equals(target) {
+		if err := setKubernetesVersion(cluster, tfile, target); err != nil {
+			return err
+		}
+		logrus.Warnf("Upgrading %v to Kubernetes %v although its cluster file sets %q; update the cluster file or the next wk cluster run reverts the upgrade.", cluster.Name, target, c.Spec.KubernetesVersion)
+	}
+
+	kopsEnv, err := clusterEnv(ctx, file, cluster)
+	if err != nil {
+		return err
+	}
+	exists, err := clusterExists(ctx, cluster.Name, kopsEnv)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("cluster %v does not exist in state store, create it with wk cluster --create", cluster.Name)
+	}
+	if !opts.Preview {
+		lockCtx, release, err := acquireLock(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		defer release()
+		ctx = lockCtx
+	}
+
+	applyOpts := ClusterApplyOptions{NoUpdate: true, AutoApprove: true, Timeouts: opts.Timeouts}
+	if applyOpts.retry, err = retryPolicies(file); err != nil {
+		return err
+	}
+	s, err := pendingChanges(ctx, cluster, file, tfile, kopsEnv, true, applyOpts)
+	if err != nil {
+		return err
+	}
+	current, err := currentKubernetesVersion(s, target)
+	if err != nil {
+		return err
+	}
+	if current.Equals(target) {
+		logrus.Infof("Cluster %v already runs Kubernetes %v.", cluster.Name, target)
+		return nil
+	}
+
+	kopsVer, err := kopsVersion(ctx, envKopsBinary(kopsEnv))
+	if err != nil {
+		return fmt.Errorf("could not detect kops version: %v", err)
+	}
+	issues := checkVersionSkew(current, target, kopsVer)
+	images, err := loadImageCompatibility(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	issues = append(issues, checkImages(cluster, target, images)...)
+	apiIssues, err := checkChannelAPIs(ctx, file, target)
+	if err != nil {
+		return err
+	}
+	issues = append(issues, apiIssues...)
+	if len(issues) > 0 {
+		fmt.Printf("\nUpgrade of %v to Kubernetes %v is blocked:\n", cluster.Name, target)
+		for _, issue := range issues {
+			fmt.Printf("  - %v\n", issue)
+		}
+		return fmt.Errorf("%v upgrade checks failed for %v", len(issues), cluster.Name)
+	}
+
+	igs := []string{}
+	for _, ig := range cluster.Kops.InstanceGroups {
+		igs = append(igs, ig.Name)
+	}
+	if len(opts.RollingUpdate.InstanceGroups) > 0 {
+		igs = intersect(igs, opts.RollingUpdate.InstanceGroups)
+	}
+	printUpgradePlan(os.Stdout, cluster.Name, current, target, s, rollingOrder(cluster, igs, opts.RollingUpdate.Groups))
+	if opts.Preview {
+		return nil
+	}
+
+	apply := func(ctx context.Context) error { return applyCluster(ctx, file, cluster, tfile, applyOpts) }
+	if err := runUpgradeSteps(ctx, file, cluster, kopsEnv, igs, opts, applyOpts, apply); err != nil {
+		return err
+	}
+	logrus.Infof("Cluster %v runs Kubernetes %v.", cluster.Name, target)
+	return nil
+}
+
+// setKubernetesVersion sets the Kubernetes version of the cluster and of its
+// rendered file tfile, which kops edit reads the cluster from
+func setKubernetesVersion(cluster *types.Cluster, tfile string, version semver.Version) error {
+	spec, ok := cluster.Kops.Cluster["spec"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("cluster spec is missing")
+	}
+	spec["kubernetesVersion"] = version.String()
+	b, err := json.Marshal(cluster)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tfile, b, 0600)
+}
+
+// runUpgradeSteps runs the steps of an upgrade in order, asking before each
+// unless opts.AutoApprove is set. apply writes the cluster to the state store.
+// The run stops after the update of clusters using the terraform target.
+func runUpgradeSteps(ctx context.Context, file string, cluster *types.Cluster, kopsEnv, igs []string, opts UpgradeOptions, applyOpts ClusterApplyOptions, apply func(context.Context) error) error {
+	updateTarget, err := clusterTarget(cluster)
+	if err != nil {
+		return err
+	}
+	for _, step := range upgradeSteps {
+		if !opts.AutoApprove {
+			ok, err := confirm(opts.In, fmt.Sprintf("Run step %v of the upgrade of %v?", step, cluster.Name))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("upgrade of %v stopped before step %v", cluster.Name, step)
+			}
+		}
+		switch step {
+		case "apply":
+			err = apply(ctx)
+		case "update":
+			err = runStep(ctx, applyOpts, "update", opts.Timeouts.Update, func(ctx context.Context) error { return updateCluster(ctx, file, cluster, kopsEnv) })
+			if err == nil && updateTarget == TargetTerraform {
+				logrus.Warnf("Cluster %v uses the terraform target; apply the terraform output, then roll and validate it with wk cluster rolling-update and wk cluster validate.", cluster.Name)
+				return nil
+			}
+		case "rolling-update":
+			err = runStep(ctx, applyOpts, "rolling-update", 0, func(ctx context.Context) error {
+				return rollingUpdate(ctx, cluster, kopsEnv, igs, opts.RollingUpdate)
+			})
+		case "validate":
+			err = runStep(ctx, applyOpts, "validate", 0, func(ctx context.Context) error {
+				return validateCluster(ctx, cluster, kopsEnv, opts.ValidateTimeout)
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("upgrade of %v failed at step %v: %w", cluster.Name, step, err)
+		}
+	}
+	return nil
+}
+
+// currentKubernetesVersion returns the Kubernetes version in the state store
+// from the pending changes, or target if its version is unchanged
+func currentKubernetesVersion(s *State, target semver.Version) (semver.Version, error) {
+	for _, c := range s.Cluster.Changes {
+		if c.Path != "spec.kubernetesVersion" {
+			continue
+		}
+		old, _ := c.Old.(string)
+		v, err := semver.ParseTolerant(old)
+		if err != nil {
+			return semver.Version{}, fmt.Errorf("could not parse Kubernetes version %q of the state store: %v", old, err)
+		}
+		return v, nil
+	}
+	return target, nil
+}
+
+// checkVersionSkew checks that target is the same or the next minor version
+// after current and that kops supports it
+func checkVersionSkew(current, target, kopsVer semver.Version) []string {
+	issues := []string{}
+	if target.LT(current) {
+		issues = append(issues, fmt.Sprintf("Kubernetes %v is older than the current version %v, downgrades are not supported", target, current))
+	} else if target.Major != current.Major || target.Minor > current.Minor+1 {
+		issues = append(issues, fmt.Sprintf("upgrading from Kubernetes %v to %v skips minor versions, upgrade one minor version at a time", current, target))
+	}
+	if !minorAtLeast(kopsVer, target) {
+		issues = append(issues, fmt.Sprintf("kops %v does not support Kubernetes %v, kops %v.%v or newer is required", kopsVer, target, target.Major, target.Minor))
+	}
+	return issues
+}
+
+// loadImageCompatibility reads the image compatibility table of the workspace
+// of file, mapping images to the range of Kubernetes versions they support
+func loadImageCompatibility(file string) (map[string]string, error) {
+	conf, err := util.GetConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	path := conf.ImageCompatibility
+	if path == "" {
+		path = defaultImageCompatibility
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(conf.ContextDir, path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := map[string]string{}
+	if err := yaml.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("could not read image compatibility table %v: %v", path, err)
+	}
+	return t, nil
+}
+
+// checkImages checks that the images of the instance groups support target.
+// Instance groups without an image use the default image of kops.
+func checkImages(cluster *types.Cluster, target semver.Version, images map[string]string) []string {
+	issues := []string{}
+	for _, ig := range cluster.Kops.InstanceGroups {
+		g := &kopsInstanceGroup{}
+		if err := decodeSpec(ig.Value, g); err != nil {
+			issues = append(issues, fmt.Sprintf("could not decode instance group %v: %v", ig.Name, err))
+			continue
+		}
+		image := g.Spec.Image
+		if image == "" {
+			continue
+		}
+		supported, ok := images[image]
+		if !ok {
+			issues = append(issues, fmt.Sprintf("image %v of instance group %v is missing from the image compatibility table", image, ig.Name))
+			continue
+		}
+		versions, err := semver.ParseRange(supported)
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("invalid Kubernetes versions %q of image %v: %v", supported, image, err))
+			continue
+		}
+		if !versions(target) {
+			issues = append(issues, fmt.Sprintf("image %v of instance group %v does not support Kubernetes %v (supports %v)", image, ig.Name, target, supported))
+		}
+	}
+	return issues
+}
+
+// checkChannelAPIs renders the channels of the cluster and lists the objects
+// using APIs removed in target
+func checkChannelAPIs(ctx context.Context, file string, target semver.Version) ([]string, error) {
+	dir, err := ioutil.TempDir("", "wk-upgrade")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if _, _, _, err := renderChannels(ctx, file, dir, nil); err != nil {
+		return nil, fmt.Errorf("could not render channels: %v", err)
+	}
+	return removedAPIUses(dir, target)
+}
+
+func printUpgradePlan(out io.Writer, name string, current, target semver.Version, s *State, rolling []RollingUpdateStep) {
+	fmt.Fprintf(out, "\nUpgrade of %v from Kubernetes %v to %v:\n\n%v\n", name, current, target, s.renderDiffs())
+	fmt.Fprintln(out, "Steps:")
+	fmt.Fprintln(out, "  1. apply: write the cluster and instance groups to the state store")
+	fmt.Fprintln(out, "  2. update: run kops update cluster")
+	fmt.Fprintln(out, "  3. rolling-update: roll instance groups in order:")
+	for _, step := range rolling {
+		fmt.Fprintf(out, "       %v\n", strings.Join(step.InstanceGroups, ", "))
+	}
+	fmt.Fprintln(out, "  4. validate: wait for the cluster to validate")
+}
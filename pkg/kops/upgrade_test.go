@@ -0,0 +1,179 @@
+package kops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+
+	"github.com/wish/wk/pkg/specdiff"
+	"github.com/wish/wk/pkg/types"
+)
+
+func TestCheckVersionSkew(t *testing.T) {
+	v := semver.MustParse
+	for _, tc := range []struct {
+		current, target, kops string
+		issues                []string
+	}{
+		{"1.15.3", "1.16.2", "1.16.0", nil},
+		{"1.15.3", "1.15.5", "1.15.0", nil},
+		{"1.15.3", "1.17.0", "1.17.0", []string{"skips minor versions"}},
+		{"1.16.2", "1.15.3", "1.16.0", []string{"downgrades are not supported"}},
+		{"1.15.3", "1.16.2", "1.15.2", []string{"kops 1.16 or newer is required"}},
+	} {
+		issues := checkVersionSkew(v(tc.current), v(tc.target), v(tc.kops))
+		if len(issues) != len(tc.issues) {
+			t.Errorf("%v -> %v with kops %v: unexpected issues %q", tc.current, tc.target, tc.kops, issues)
+			continue
+		}
+		for i, want := range tc.issues {
+			if !strings.Contains(issues[i], want) {
+				t.Errorf("%q does not contain %q", issues[i], want)
+			}
+		}
+	}
+}
+
+func TestCheckImages(t *testing.T) {
+	ig := func(name, image string) types.InstanceGroup {
+		return types.InstanceGroup{Name: name, Value: map[string]interface{}{
+			"spec": map[string]interface{}{"role": "Node", "image": image},
+		}}
+	}
+	cluster := &types.Cluster{Name: "test.k8s.local", Kops: &types.Kops{InstanceGroups: []types.InstanceGroup{
+		ig("nodes", "stretch-2020-01"),
+		ig("old", "jessie-2018-01"),
+		ig("custom", "custom-ami"),
+		ig("default", ""),
+	}}}
+	images := map[string]string{
+		"stretch-2020-01": ">=1.14.0 <1.18.0",
+		"jessie-2018-01":  ">=1.9.0 <1.12.0",
+	}
+	issues := checkImages(cluster, semver.MustParse("1.16.2"), images)
+	if len(issues) != 2 || !strings.Contains(issues[0], "instance group old does not support") || !strings.Contains(issues[1], "custom-ami") {
+		t.Errorf("unexpected issues %q", issues)
+	}
+}
+
+func TestFindRemovedAPIs(t *testing.T) {
+	data := []byte(`{"apiVersion": "extensions/v1beta1", "kind": "Deployment", "metadata": {"name": "web"}}
+---
+{"apiVersion": "v1", "kind": "List", "items": [{"apiVersion": "batch/v1beta1", "kind": "CronJob", "metadata": {"name": "cleanup"}}]}
+---
+{"ingress": {"apiVersion": "networking.k8s.io/v1beta1", "kind": "Ingress", "metadata": {"name": "web"}}}
+`)
+	found, err := findRemovedAPIs(data, semver.MustParse("1.16.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || !strings.Contains(found[0], "Deployment web is removed in Kubernetes 1.16, use apps/v1") {
+		t.Errorf("unexpected APIs for 1.16: %q", found)
+	}
+	found, err = findRemovedAPIs(data, semver.MustParse("1.25.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 {
+		t.Errorf("unexpected APIs for 1.25: %q", found)
+	}
+}
+
+func TestCurrentKubernetesVersion(t *testing.T) {
+	target := semver.MustParse("1.16.2")
+	s := newState()
+	if v, err := currentKubernetesVersion(s, target); err != nil || !v.Equals(target) {
+		t.Errorf("expected target without changes, got %v, %v", v, err)
+	}
+	s.Cluster.Changes = []specdiff.Change{{Path: "spec.kubernetesVersion", Op: specdiff.Changed, Old: "v1.15.3", New: "1.16.2"}}
+	if v, err := currentKubernetesVersion(s, target); err != nil || v.String() != "1.15.3" {
+		t.Errorf("unexpected current version %v, %v", v, err)
+	}
+}
+
+func TestSetKubernetesVersion(t *testing.T) {
+	f, err := ioutil.TempFile("", "wk-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	cluster := testCluster()
+	cluster.Kops.Cluster = map[string]interface{}{"spec": map[string]interface{}{"kubernetesVersion": "1.15.3"}}
+
+	if err := setKubernetesVersion(cluster, f.Name(), semver.MustParse("1.16.2")); err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := ReadClusterFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*types.Cluster{cluster, rendered} {
+		if v := c.Kops.Cluster["spec"].(map[string]interface{})["kubernetesVersion"]; v != "1.16.2" {
+			t.Errorf("unexpected kubernetesVersion %v", v)
+		}
+	}
+	if len(rendered.Kops.InstanceGroups) != len(cluster.Kops.InstanceGroups) {
+		t.Errorf("instance groups missing from rendered cluster %+v", rendered.Kops)
+	}
+}
+
+func TestRunUpgradeSteps(t *testing.T) {
+	log, cleanup := stubKops(t, `case "$1" in validate) echo '{"nodes":[{"name":"ip-10-0-0-1","status":"True"}]}';; esac`)
+	defer cleanup()
+	applied := 0
+	apply := func(context.Context) error {
+		applied++
+		return nil
+	}
+	opts := UpgradeOptions{AutoApprove: true, ValidateTimeout: time.Second}
+
+	if err := runUpgradeSteps(context.Background(), "cluster.jsonnet", testCluster(), os.Environ(), []string{"nodes-a"}, opts, ClusterApplyOptions{}, apply); err != nil {
+		t.Fatal(err)
+	}
+	if applied != 1 {
+		t.Errorf("expected one apply, got %v", applied)
+	}
+	calls := readCalls(t, log)
+	if len(calls) != 3 || !strings.HasPrefix(calls[0], "update cluster --name=test.k8s.local") ||
+		calls[1] != "rolling-update cluster --name=test.k8s.local --yes --instance-group=nodes-a --fail-on-validate-error=false" ||
+		!strings.HasPrefix(calls[2], "validate cluster") {
+		t.Errorf("unexpected calls %q", calls)
+	}
+}
+
+func TestRunUpgradeStepsStopsBeforeStep(t *testing.T) {
+	log, cleanup := stubKops(t, "")
+	defer cleanup()
+	applied := 0
+	apply := func(context.Context) error {
+		applied++
+		return nil
+	}
+
+	// Declining the first step runs nothing
+	opts := UpgradeOptions{In: strings.NewReader("n\n")}
+	err := runUpgradeSteps(context.Background(), "cluster.jsonnet", testCluster(), os.Environ(), []string{"nodes-a"}, opts, ClusterApplyOptions{}, apply)
+	if err == nil || !strings.Contains(err.Error(), "stopped before step apply") {
+		t.Errorf("expected stop before apply, got %v", err)
+	}
+	if _, statErr := os.Stat(log); applied != 0 || !os.IsNotExist(statErr) {
+		t.Errorf("expected no step to run, applied %v times", applied)
+	}
+
+	// Each step is asked for on the same input
+	opts.In = strings.NewReader("y\nyes\nn\n")
+	err = runUpgradeSteps(context.Background(), "cluster.jsonnet", testCluster(), os.Environ(), []string{"nodes-a"}, opts, ClusterApplyOptions{}, apply)
+	if err == nil || !strings.Contains(err.Error(), "stopped before step rolling-update") {
+		t.Errorf("expected stop before rolling-update, got %v", err)
+	}
+	calls := readCalls(t, log)
+	if applied != 1 || len(calls) != 1 || !strings.HasPrefix(calls[0], "update cluster --name=test.k8s.local") {
+		t.Errorf("expected apply and update only, applied %v times and got calls %q", applied, calls)
+	}
+}
@@ -1,18 +1,17 @@
 package kops
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
-	"strings"
-
-	godebug_diff "github.com/kylelemons/godebug/diff"
-	"github.com/sirupsen/logrus"
 
+	"github.com/wish/wk/pkg/jsonnet"
+	"github.com/wish/wk/pkg/specdiff"
 	"github.com/wish/wk/pkg/types"
-	"github.com/wish/wk/pkg/util"
 )
 
 func CopyFile(src, dest string) error {
@@ -44,24 +43,38 @@ func ReadClusterFile(path string) (*types.Cluster, error) {
 	return cluster, nil
 }
 
-// diff returns whether the structures are different, and a textual diff of the changes
-func diff(old, new map[string]interface{}) (bool, string) {
-	if !reflect.DeepEqual(old, new) {
-		var A, B []byte
-		var err error
-		if A, err = json.MarshalIndent(&old, "", "  "); err != nil {
-			logrus.Fatalf("Error marshalling old to JSON: %v", err)
-		}
-		if B, err = json.MarshalIndent(&new, "", "  "); err != nil {
-			logrus.Fatalf("Error marshalling new to JSON: %v", err)
-		}
-		textDiff := util.Render(godebug_diff.DiffChunks(
-			strings.Split(string(A), "\n"),
-			strings.Split(string(B), "\n"),
-		))
+// expandKopsCluster renders the cluster file and checks it has kops configuration
+func expandKopsCluster(ctx context.Context, file string) (*types.Cluster, error) {
+	cluster, _, err := jsonnet.ExpandCluster(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.Kops == nil {
+		return nil, fmt.Errorf("kops configuration is missing")
+	}
+	return cluster, nil
+}
 
-		return false, textDiff
+// clusterEnv returns the environment kops should run with for the cluster,
+// including the kops binary matching the cluster's required version
+func clusterEnv(ctx context.Context, file string, cluster *types.Cluster) ([]string, error) {
+	bin, err := kopsBinary(ctx, file, cluster)
+	if err != nil {
+		return nil, err
 	}
+	env := os.Environ()
+	for k, v := range cluster.Kops.Env {
+		env = append(env, fmt.Sprintf("%v=%v", k, v))
+	}
+	return append(env, fmt.Sprintf("%v=%v", kopsBinaryEnv, bin)), nil
+}
 
-	return true, ""
+// diff returns whether the structures are equal, a textual diff of the changes
+// and the structural changes themselves
+func diff(old, new map[string]interface{}) (bool, string, []specdiff.Change) {
+	if reflect.DeepEqual(old, new) {
+		return true, "", nil
+	}
+	changes := specdiff.Compute(old, new)
+	return false, specdiff.Render(changes), changes
 }
@@ -0,0 +1,65 @@
+package kops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+// stubKops puts a fake kops binary first in PATH which records its arguments
+// and then runs the given shell script. It returns the path of the record file
+// and a function restoring PATH.
+func stubKops(t *testing.T, body string) (string, func()) {
+	return stubCommand(t, "kops", body)
+}
+
+// stubCommand is stubKops for any command name
+func stubCommand(t *testing.T, name, body string) (string, func()) {
+	dir, err := ioutil.TempDir("", "wk-"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\n" + body + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return log, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func readCalls(t *testing.T, log string) []string {
+	b, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func testCluster() *types.Cluster {
+	ig := func(name, role string) types.InstanceGroup {
+		return types.InstanceGroup{Name: name, Value: map[string]interface{}{
+			"spec": map[string]interface{}{"role": role},
+		}}
+	}
+	return &types.Cluster{
+		Name: "test.k8s.local",
+		Kops: &types.Kops{
+			InstanceGroups: []types.InstanceGroup{
+				ig("nodes-a", "Node"),
+				ig("master-a", "Master"),
+				ig("nodes-b", "Node"),
+				ig("master-b", "Master"),
+				ig("bastion", "Bastion"),
+			},
+		},
+	}
+}
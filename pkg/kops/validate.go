@@ -0,0 +1,138 @@
+package kops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/wk/pkg/types"
+)
+
+// ValidationExitCode is the exit code used when a cluster fails to validate
+const ValidationExitCode = 2
+
+// validatePollInterval is the time between `kops validate cluster` attempts
+var validatePollInterval = 15 * time.Second
+
+// ValidationResult is the output of `kops validate cluster -o json`
+type ValidationResult struct {
+	Failures []ValidationFailure `json:"failures,omitempty"`
+	Nodes    []ValidationNode    `json:"nodes,omitempty"`
+}
+
+// ValidationFailure is a component or node failing validation
+type ValidationFailure struct {
+	Kind    string `json:"type,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ValidationNode is a node seen during validation
+type ValidationNode struct {
+	Name     string `json:"name,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// ValidationError is returned when a cluster did not validate before the timeout
+type ValidationError struct {
+	Cluster string
+	Timeout time.Duration
+	// Result is the last result returned by kops, nil if kops never produced one
+	Result *ValidationResult
+	// Err is the last error running kops
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Result != nil && len(e.Result.Failures) > 0 {
+		return fmt.Sprintf("cluster %v failed to validate within %v: %v failures", e.Cluster, e.Timeout, len(e.Result.Failures))
+	}
+	return fmt.Sprintf("cluster %v failed to validate within %v: %v", e.Cluster, e.Timeout, e.Err)
+}
+
+// ClusterValidate renders the cluster file and waits for the cluster to validate
+func ClusterValidate(ctx context.Context, file string, timeout time.Duration) error {
+	cluster, err := expandKopsCluster(ctx, file)
+	if err != nil {
+		return err
+	}
+	kopsEnv, err := clusterEnv(ctx, file, cluster)
+	if err != nil {
+		return err
+	}
+	return validateCluster(ctx, cluster, kopsEnv, timeout)
+}
+
+// validateCluster polls `kops validate cluster` until it succeeds or timeout passes
+func validateCluster(ctx context.Context, cluster *types.Cluster, env []string, timeout time.Duration) error {
+	logrus.Infof("Validating cluster %v, timeout %v.", cluster.Name, timeout)
+	deadline := time.Now().Add(timeout)
+	// A hanging kops must not outlive the timeout
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	for {
+		result, err := runValidate(ctx, cluster.Name, env)
+		if err == nil && len(result.Failures) == 0 {
+			logrus.Infof("Cluster %v is valid.", cluster.Name)
+			return nil
+		}
+		if result != nil {
+			logrus.Infof("Cluster %v is not valid yet: %v failures.", cluster.Name, len(result.Failures))
+		} else {
+			logrus.Infof("Cluster %v is not valid yet: %v", cluster.Name, err)
+		}
+
+		if time.Now().Add(validatePollInterval).After(deadline) {
+			if result != nil {
+				printValidation(os.Stdout, result)
+			}
+			return &ValidationError{Cluster: cluster.Name, Timeout: timeout, Result: result, Err: err}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(validatePollInterval):
+		}
+	}
+}
+
+// runValidate runs a single `kops validate cluster`. The result is nil when
+// kops did not print one, e.g. because the API server is not reachable.
+func runValidate(ctx context.Context, name string, env []string) (*ValidationResult, error) {
+	out := &bytes.Buffer{}
+	vCmd := kopsCommand(ctx, env, "validate", "cluster", "--name="+name, "-o", "json")
+	vCmd.Stdout = out
+	runErr := vCmd.Run()
+
+	start := bytes.IndexByte(out.Bytes(), '{')
+	if start < 0 {
+		if runErr == nil {
+			runErr = fmt.Errorf("no validation result")
+		}
+		return nil, runErr
+	}
+	result := &ValidationResult{}
+	if err := json.Unmarshal(out.Bytes()[start:], result); err != nil {
+		return nil, fmt.Errorf("could not parse validation result: %v", err)
+	}
+	return result, runErr
+}
+
+func printValidation(out io.Writer, result *ValidationResult) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tMESSAGE")
+	for _, f := range result.Failures {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", f.Kind, f.Name, f.Message)
+	}
+	w.Flush()
+}
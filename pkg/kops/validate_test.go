@@ -0,0 +1,32 @@
+package kops
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestValidateClusterTimeout(t *testing.T) {
+	_, cleanup := stubKops(t, `echo '{"failures":[{"type":"Node","name":"ip-10-0-0-1","message":"node not ready"}]}'; exit 1`)
+	defer cleanup()
+	validatePollInterval = 10 * time.Millisecond
+
+	err := validateCluster(context.Background(), testCluster(), os.Environ(), 50*time.Millisecond)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	if verr.Result == nil || len(verr.Result.Failures) != 1 || verr.Result.Failures[0].Name != "ip-10-0-0-1" {
+		t.Errorf("unexpected result %+v", verr.Result)
+	}
+}
+
+func TestValidateCluster(t *testing.T) {
+	_, cleanup := stubKops(t, `echo '{"nodes":[{"name":"ip-10-0-0-1","status":"True"}]}'`)
+	defer cleanup()
+
+	if err := validateCluster(context.Background(), testCluster(), os.Environ(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,210 @@
+package specdiff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// segment is a single step of a Pattern. It matches a map key, a list index,
+// or with wildcard set, any key or index.
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Pattern is a parsed JSON path with optional wildcards, in the same syntax
+// used by Change paths: `spec.subnets[*].id`, `spec.kubelet.*`,
+// `metadata.annotations["kops.k8s.io/foo"]`.
+type Pattern []segment
+
+// ParsePattern parses a JSON path pattern
+func ParsePattern(s string) (Pattern, error) {
+	p := Pattern{}
+	rest := s
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if strings.HasPrefix(rest, `["`) {
+				if end = strings.Index(rest[2:], `"]`); end >= 0 {
+					end += 3
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unterminated '['", s)
+			}
+			inner := rest[1:end]
+			switch {
+			case inner == "*":
+				p = append(p, segment{wildcard: true})
+			case strings.HasPrefix(inner, `"`):
+				key, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: %v", s, err)
+				}
+				p = append(p, segment{key: key})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("invalid path %q: bad index %q", s, inner)
+				}
+				p = append(p, segment{index: i, isIndex: true})
+			}
+			rest = rest[end+1:]
+			continue
+		}
+
+		if len(p) > 0 {
+			if rest[0] != '.' {
+				return nil, fmt.Errorf("invalid path %q: expected '.' or '['", s)
+			}
+			rest = rest[1:]
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		key := rest[:end]
+		if key == "" {
+			return nil, fmt.Errorf("invalid path %q: empty key", s)
+		}
+		p = append(p, segment{key: key, wildcard: key == "*"})
+		rest = rest[end:]
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return p, nil
+}
+
+// ParsePatterns parses a list of JSON path patterns
+func ParsePatterns(paths []string) ([]Pattern, error) {
+	out := make([]Pattern, 0, len(paths))
+	for _, s := range paths {
+		p, err := ParsePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (s segment) matchesKey(k string) bool {
+	return s.wildcard || (!s.isIndex && s.key == k)
+}
+
+func (s segment) matchesIndex(i int) bool {
+	return s.wildcard || (s.isIndex && s.index == i)
+}
+
+// Strip removes all values matching the pattern from obj and returns the
+// result. Maps are modified in place, lists may be replaced.
+func Strip(obj interface{}, p Pattern) interface{} {
+	if len(p) == 0 {
+		return obj
+	}
+	seg, rest := p[0], p[1:]
+	switch o := obj.(type) {
+	case map[string]interface{}:
+		for k, v := range o {
+			if !seg.matchesKey(k) {
+				continue
+			}
+			if len(rest) == 0 {
+				delete(o, k)
+			} else {
+				o[k] = Strip(v, rest)
+			}
+		}
+	case []interface{}:
+		out := make([]interface{}, 0, len(o))
+		for i, v := range o {
+			if !seg.matchesIndex(i) {
+				out = append(out, v)
+			} else if len(rest) > 0 {
+				out = append(out, Strip(v, rest))
+			}
+		}
+		return out
+	}
+	return obj
+}
+
+// Preserve copies the values matching the pattern from old into new and
+// returns the result. Missing intermediate maps are created in new, so a
+// preserved field is kept even if new does not mention its parent. List
+// elements are matched the same way as in Compute.
+func Preserve(old, new interface{}, p Pattern) interface{} {
+	if len(p) == 0 {
+		return old
+	}
+	seg, rest := p[0], p[1:]
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n, ok := new.(map[string]interface{})
+		if new == nil {
+			n, ok = map[string]interface{}{}, true
+		}
+		if !ok {
+			return new
+		}
+		for k, ov := range o {
+			if !seg.matchesKey(k) {
+				continue
+			}
+			if len(rest) == 0 {
+				n[k] = ov
+				continue
+			}
+			nv, inNew := n[k]
+			r := Preserve(ov, nv, rest)
+			if m, isMap := r.(map[string]interface{}); !inNew && (!isMap || len(m) == 0) {
+				continue
+			}
+			n[k] = r
+		}
+		return n
+	case []interface{}:
+		n, ok := new.([]interface{})
+		if !ok {
+			return new
+		}
+		for i, j := range matchElements(o, n) {
+			if !seg.matchesIndex(i) {
+				continue
+			}
+			if len(rest) == 0 {
+				n[j] = o[i]
+			} else {
+				n[j] = Preserve(o[i], n[j], rest)
+			}
+		}
+		return n
+	}
+	return new
+}
+
+// matchElements maps indices of old to the indices of the matching elements of new
+func matchElements(old, new []interface{}) map[int]int {
+	m := map[int]int{}
+	key := listKey(old, new)
+	if key == "" {
+		for i := 0; i < len(old) && i < len(new); i++ {
+			m[i] = i
+		}
+		return m
+	}
+	newIdx := map[interface{}]int{}
+	for j, n := range new {
+		newIdx[n.(map[string]interface{})[key]] = j
+	}
+	for i, o := range old {
+		if j, ok := newIdx[o.(map[string]interface{})[key]]; ok {
+			m[i] = j
+		}
+	}
+	return m
+}
@@ -0,0 +1,216 @@
+// Package specdiff implements a structural diff of decoded JSON/YAML objects
+// such as kops Cluster and InstanceGroup specs.
+package specdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Op is the kind of a single change
+type Op string
+
+const (
+	// Added means the path exists only in the new object
+	Added Op = "add"
+	// Removed means the path exists only in the old object
+	Removed Op = "remove"
+	// Changed means the path exists in both objects with different values
+	Changed Op = "change"
+)
+
+// Change is a single difference between two objects, addressed by a JSON path
+// such as `spec.subnets[2].cidr`.
+type Change struct {
+	Path string      `json:"path"`
+	Op   Op          `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// ListKeys are the fields used to match list elements between the old and the
+// new object. The first field that is present and unique in all elements of
+// both lists is used; otherwise elements are matched by index.
+var ListKeys = []string{"name"}
+
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Compute returns the changes needed to turn old into new. Map keys are
+// visited in sorted order, so the result is stable across runs.
+func Compute(old, new interface{}) []Change {
+	changes := []Change{}
+	walk("", old, new, &changes)
+	return changes
+}
+
+func walk(path string, old, new interface{}, changes *[]Change) {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if n, ok := new.(map[string]interface{}); ok {
+			walkMap(path, o, n, changes)
+			return
+		}
+	case []interface{}:
+		if n, ok := new.([]interface{}); ok {
+			walkList(path, o, n, changes)
+			return
+		}
+	}
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, Change{Path: path, Op: Changed, Old: old, New: new})
+	}
+}
+
+func walkMap(path string, old, new map[string]interface{}, changes *[]Change) {
+	keys := make([]string, 0, len(old)+len(new))
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := fieldPath(path, k)
+		o, inOld := old[k]
+		n, inNew := new[k]
+		switch {
+		case !inOld:
+			*changes = append(*changes, Change{Path: p, Op: Added, New: n})
+		case !inNew:
+			*changes = append(*changes, Change{Path: p, Op: Removed, Old: o})
+		default:
+			walk(p, o, n, changes)
+		}
+	}
+}
+
+func walkList(path string, old, new []interface{}, changes *[]Change) {
+	key := listKey(old, new)
+	if key == "" {
+		for i := 0; i < len(old) || i < len(new); i++ {
+			p := indexPath(path, i)
+			switch {
+			case i >= len(old):
+				*changes = append(*changes, Change{Path: p, Op: Added, New: new[i]})
+			case i >= len(new):
+				*changes = append(*changes, Change{Path: p, Op: Removed, Old: old[i]})
+			default:
+				walk(p, old[i], new[i], changes)
+			}
+		}
+		return
+	}
+
+	oldIdx := map[interface{}]int{}
+	for i, o := range old {
+		oldIdx[o.(map[string]interface{})[key]] = i
+	}
+	seen := map[interface{}]bool{}
+	for i, n := range new {
+		k := n.(map[string]interface{})[key]
+		seen[k] = true
+		p := indexPath(path, i)
+		if j, ok := oldIdx[k]; ok {
+			walk(p, old[j], n, changes)
+		} else {
+			*changes = append(*changes, Change{Path: p, Op: Added, New: n})
+		}
+	}
+	for i, o := range old {
+		if !seen[o.(map[string]interface{})[key]] {
+			*changes = append(*changes, Change{Path: indexPath(path, i), Op: Removed, Old: o})
+		}
+	}
+}
+
+// listKey returns the first of ListKeys usable to match elements of both lists
+func listKey(old, new []interface{}) string {
+	for _, key := range ListKeys {
+		if uniqueKey(old, key) && uniqueKey(new, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+func uniqueKey(list []interface{}, key string) bool {
+	seen := map[interface{}]bool{}
+	for _, e := range list {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		v, ok := m[key]
+		if !ok {
+			return false
+		}
+		switch v.(type) {
+		case string, float64, bool:
+		default:
+			return false
+		}
+		if seen[v] {
+			return false
+		}
+		seen[v] = true
+	}
+	return true
+}
+
+func fieldPath(path, key string) string {
+	if !identRe.MatchString(key) {
+		return fmt.Sprintf("%v[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%v[%d]", path, i)
+}
+
+// Render renders changes one per line, prefixing additions with '+', removals
+// with '-' and modifications with '~'.
+func Render(changes []Change) string {
+	buf := new(strings.Builder)
+	for _, c := range changes {
+		switch c.Op {
+		case Added:
+			fmt.Fprintf(buf, "+ %v: %v\n", c.Path, formatValue(c.New))
+		case Removed:
+			fmt.Fprintf(buf, "- %v: %v\n", c.Path, formatValue(c.Old))
+		default:
+			fmt.Fprintf(buf, "~ %v: %v -> %v\n", c.Path, formatValue(c.Old), formatValue(c.New))
+		}
+	}
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// JSON renders changes as an indented JSON document
+func JSON(changes []Change) ([]byte, error) {
+	if changes == nil {
+		changes = []Change{}
+	}
+	return json.MarshalIndent(changes, "", "  ")
+}
+
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
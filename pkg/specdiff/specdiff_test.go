@@ -0,0 +1,114 @@
+package specdiff
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decode(t *testing.T, s string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestCompute(t *testing.T) {
+	old := decode(t, `{
+		"metadata": {"name": "a", "labels": {"kops.k8s.io/cluster": "a"}},
+		"spec": {
+			"subnets": [
+				{"name": "us-west-2a", "cidr": "10.0.0.0/19"},
+				{"name": "us-west-2b", "cidr": "10.0.64.0/19"},
+				{"name": "us-west-2c", "cidr": "10.0.0.0/19"}
+			],
+			"sshAccess": ["0.0.0.0/0"]
+		}
+	}`)
+	new := decode(t, `{
+		"metadata": {"name": "a", "labels": {"kops.k8s.io/cluster": "b"}},
+		"spec": {
+			"subnets": [
+				{"name": "us-west-2b", "cidr": "10.0.64.0/19"},
+				{"name": "us-west-2d", "cidr": "10.0.96.0/19"},
+				{"name": "us-west-2c", "cidr": "10.0.32.0/19"}
+			],
+			"sshAccess": ["0.0.0.0/0", "10.0.0.0/8"],
+			"kubernetesVersion": "1.13.5"
+		}
+	}`)
+
+	got := Render(Compute(old, new))
+	want := `~ metadata.labels["kops.k8s.io/cluster"]: a -> b
++ spec.kubernetesVersion: 1.13.5
++ spec.sshAccess[1]: 10.0.0.0/8
++ spec.subnets[1]: {"cidr":"10.0.96.0/19","name":"us-west-2d"}
+~ spec.subnets[2].cidr: 10.0.0.0/19 -> 10.0.32.0/19
+- spec.subnets[0]: {"cidr":"10.0.0.0/19","name":"us-west-2a"}`
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestComputeEqual(t *testing.T) {
+	a := decode(t, `{"spec": {"hooks": [{"name": "a", "before": ["x"]}]}}`)
+	b := decode(t, `{"spec": {"hooks": [{"name": "a", "before": ["x"]}]}}`)
+	if changes := Compute(a, b); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	for _, s := range []string{"spec", "spec.subnets[*].id", "spec.kubelet.*", `metadata.annotations["kops.k8s.io/foo"]`, "spec.subnets[0]"} {
+		if _, err := ParsePattern(s); err != nil {
+			t.Errorf("%v: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", ".spec", "spec..a", "spec[", "spec[a]", "spec[0]a"} {
+		if _, err := ParsePattern(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestStripAndPreserve(t *testing.T) {
+	old := decode(t, `{
+		"metadata": {"creationTimestamp": "2019-01-01", "generation": 3},
+		"spec": {
+			"kubelet": {"anonymousAuth": false},
+			"subnets": [
+				{"name": "a", "id": "subnet-a"},
+				{"name": "b", "id": "subnet-b"}
+			]
+		}
+	}`)
+	new := decode(t, `{
+		"spec": {
+			"subnets": [
+				{"name": "b"},
+				{"name": "a"}
+			]
+		}
+	}`)
+	for _, s := range []string{"metadata.*", "spec.subnets[*].id", "spec.kubelet.anonymousAuth", "spec.kubelet.missing"} {
+		p, err := ParsePattern(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		Preserve(old, new, p)
+	}
+	if changes := Compute(old, new); len(changes) != 0 {
+		t.Errorf("expected no changes after preserve, got:\n%v", Render(changes))
+	}
+
+	p, err := ParsePattern("spec.subnets[*].id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := Strip(old, p).(map[string]interface{})
+	for _, s := range stripped["spec"].(map[string]interface{})["subnets"].([]interface{}) {
+		if _, ok := s.(map[string]interface{})["id"]; ok {
+			t.Errorf("id not stripped: %v", s)
+		}
+	}
+}
@@ -6,12 +6,64 @@ type Cluster struct {
 }
 
 type Kops struct {
+	// Version is the kops version or version range the cluster requires,
+	// e.g. "1.11.1" or ">=1.11.0 <1.12.0"
+	Version string
 	Env     map[string]string
 	Create  map[string]interface{}
 	Cluster map[string]interface{}
 
 	InstanceGroups []InstanceGroup
 	Channels       []Channel
+
+	Diff DiffRules
+
+	// Target is the kops update target, "direct" (default) or "terraform"
+	Target string
+	// TerraformOut is the terraform output directory, relative to the workspace
+	TerraformOut string
+
+	Secrets []Secret
+}
+
+// Secret is a kops secret created from a local file
+type Secret struct {
+	// Type is the kops secret type, "sshpublickey" or "dockerconfig"
+	Type string
+	// Name of the secret, "admin" by default for sshpublickey. A dockerconfig
+	// secret is always named "dockerconfig".
+	Name string
+	// File holds the secret, relative to the workspace
+	File string
+}
+
+// DiffRules configures how the cluster and instance groups are compared with
+// the state store
+type DiffRules struct {
+	Cluster        DiffPaths
+	InstanceGroups DiffPaths
+}
+
+// DiffPaths lists JSON paths, with wildcards, such as `spec.subnets[*].id`
+type DiffPaths struct {
+	// Ignore paths are removed from both objects before diffing
+	Ignore []string
+	// Preserve paths are copied from the state store object into the rendered one
+	Preserve []string
+}
+
+// Merge appends the paths of o
+func (d DiffRules) Merge(o DiffRules) DiffRules {
+	return DiffRules{
+		Cluster: DiffPaths{
+			Ignore:   append(append([]string{}, d.Cluster.Ignore...), o.Cluster.Ignore...),
+			Preserve: append(append([]string{}, d.Cluster.Preserve...), o.Cluster.Preserve...),
+		},
+		InstanceGroups: DiffPaths{
+			Ignore:   append(append([]string{}, d.InstanceGroups.Ignore...), o.InstanceGroups.Ignore...),
+			Preserve: append(append([]string{}, d.InstanceGroups.Preserve...), o.InstanceGroups.Preserve...),
+		},
+	}
 }
 
 type InstanceGroup struct {
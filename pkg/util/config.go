@@ -8,12 +8,93 @@ import (
 	"path/filepath"
 
 	"sigs.k8s.io/yaml"
+
+	"github.com/wish/wk/pkg/types"
 )
 
 // Config contains configuration for given workspace
 type Config struct {
 	ContextDir string
 	// ChartsDir  string // TODO(tvi): Fix.
+
+	// ProtectedClusters lists names of clusters that must not be deleted
+	ProtectedClusters []string
+	// Clusters is the inventory of cluster files in the workspace
+	Clusters []ClusterEntry
+	// Diff configures ignored and preserved paths for all clusters
+	Diff types.DiffRules
+
+	// KopsVersion is the kops version or range required by clusters that do not set one
+	KopsVersion string
+	// KopsBinDir holds kops binaries named kops-<version> or <version>/kops
+	KopsBinDir string
+	// KubeContextPrefix is prepended to cluster names to form kubeconfig context names
+	KubeContextPrefix string
+	// PriceTable is the file with machine type and volume prices used to
+	// estimate costs, prices.yaml in the workspace by default
+	PriceTable string
+	// MachineTypes is the file with the vCPUs and memory of machine types
+	// used to sum up cluster capacity, machine-types.yaml in the workspace by default
+	MachineTypes string
+	// ImageCompatibility is the file mapping instance group images to the
+	// Kubernetes versions they support, image-compatibility.yaml in the
+	// workspace by default
+	ImageCompatibility string
+	// Retry configures retries of idempotent steps, by step name. The policy
+	// named "default" applies to all of them; unset fields of a step's policy
+	// are taken from it.
+	Retry map[string]RetryPolicy
+}
+
+// RetryPolicy configures retries of a failing step
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, 1 disables retries
+	Attempts int
+	// Backoff is the delay before the first retry, e.g. 10s. It doubles for
+	// every further retry up to MaxBackoff.
+	Backoff    string
+	MaxBackoff string
+	// Patterns are regular expressions matched against the error and output
+	// of the step
+	Patterns []string
+	// ExitCodes are the kops exit codes considered transient
+	ExitCodes []int
+}
+
+// ClusterEntry is a cluster file in the workspace inventory
+type ClusterEntry struct {
+	// File is the cluster file path relative to the workspace
+	File   string
+	Labels map[string]string
+}
+
+// Path returns the cluster file path relative to the working directory
+func (c *Config) Path(e ClusterEntry) string {
+	if filepath.IsAbs(e.File) {
+		return e.File
+	}
+	return filepath.Join(c.ContextDir, e.File)
+}
+
+// Select returns the inventory clusters matching the selector
+func (c *Config) Select(sel Selector) []ClusterEntry {
+	out := []ClusterEntry{}
+	for _, e := range c.Clusters {
+		if sel.Matches(e.Labels) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// IsProtected reports whether the named cluster is protected from deletion
+func (c *Config) IsProtected(name string) bool {
+	for _, p := range c.ProtectedClusters {
+		if p == name {
+			return true
+		}
+	}
+	return false
 }
 
 // GetConfig tries to find workspace configuration
@@ -1,13 +1,23 @@
 package util
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"strings"
+	"sync"
 
 	"github.com/kylelemons/godebug/diff"
 )
 
+// IsTerminal reports whether f is a terminal
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 func WriteTempFile(data []byte) (string, error) {
 	tmpfile, err := ioutil.TempFile("", "example")
 	if err != nil {
@@ -49,3 +59,49 @@ func Render(chunks []diff.Chunk) string {
 	}
 	return strings.TrimRight(buf.String(), "\n")
 }
+
+// PrefixWriter prefixes every line written to the underlying writer. Lines
+// are written whole, so several PrefixWriters can share one writer.
+type PrefixWriter struct {
+	prefix string
+	out    io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+// NewPrefixWriter returns a PrefixWriter writing to out. Writes to out are
+// serialized with mu.
+func NewPrefixWriter(out io.Writer, prefix string, mu *sync.Mutex) *PrefixWriter {
+	return &PrefixWriter{prefix: prefix, out: out, mu: mu}
+}
+
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := w.writeLine(w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Flush writes any incomplete last line
+func (w *PrefixWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLine(append(w.buf, '\n'))
+	w.buf = nil
+	return err
+}
+
+func (w *PrefixWriter) writeLine(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := fmt.Fprintf(w.out, "%v%s", w.prefix, line)
+	return err
+}
@@ -0,0 +1,20 @@
+package util
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewPrefixWriter(out, "[a] ", &sync.Mutex{})
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\nthree"))
+	w.Flush()
+
+	want := "[a] one\n[a] two\n[a] three\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Requirement is a single `key=value` or `key!=value` selector term
+type Requirement struct {
+	Key    string
+	Value  string
+	Negate bool
+}
+
+// Selector is a list of requirements which must all match, e.g. `env=prod,region!=us-east`
+type Selector []Requirement
+
+// ParseSelector parses a comma separated list of `key=value`, `key==value`
+// and `key!=value` terms
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		r := Requirement{}
+		var parts []string
+		switch {
+		case strings.Contains(term, "!="):
+			parts = strings.SplitN(term, "!=", 2)
+			r.Negate = true
+		case strings.Contains(term, "=="):
+			parts = strings.SplitN(term, "==", 2)
+		case strings.Contains(term, "="):
+			parts = strings.SplitN(term, "=", 2)
+		default:
+			return nil, fmt.Errorf("invalid selector term %q", term)
+		}
+		r.Key, r.Value = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if r.Key == "" {
+			return nil, fmt.Errorf("invalid selector term %q", term)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches reports whether labels satisfy all requirements
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, r := range sel {
+		v, ok := labels[r.Key]
+		if r.Negate == (ok && v == r.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sel Selector) String() string {
+	terms := []string{}
+	for _, r := range sel {
+		op := "="
+		if r.Negate {
+			op = "!="
+		}
+		terms = append(terms, r.Key+op+r.Value)
+	}
+	return strings.Join(terms, ",")
+}
@@ -0,0 +1,32 @@
+package util
+
+import "testing"
+
+func TestSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "region": "us-west"}
+	cases := []struct {
+		sel   string
+		match bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=prod,region=us-west", true},
+		{"env==prod,region=us-east", false},
+		{"env!=prod", false},
+		{"team!=infra", true},
+		{"team=infra", false},
+	}
+	for _, c := range cases {
+		sel, err := ParseSelector(c.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.Matches(labels); got != c.match {
+			t.Errorf("%q: got %v, want %v", c.sel, got, c.match)
+		}
+	}
+
+	if _, err := ParseSelector("env"); err == nil {
+		t.Error("expected error for term without operator")
+	}
+}
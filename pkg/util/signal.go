@@ -0,0 +1,76 @@
+package util
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+type abortKey struct{}
+
+// SignalContext returns a context that is cancelled on the first SIGINT or
+// SIGTERM, so no new step is started, while the step already running is left
+// to finish. The context returned by AbortContext is cancelled on the second
+// signal to abort the running step. A third signal kills the process.
+func SignalContext(parent context.Context) (context.Context, func()) {
+	abort, cancelAbort := context.WithCancel(parent)
+	ctx, cancel := context.WithCancel(context.WithValue(abort, abortKey{}, abort))
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+		case <-abort.Done():
+			return
+		}
+		logrus.Warnln("Interrupted, finishing the current step. Interrupt again to abort it.")
+		cancel()
+
+		select {
+		case <-sigs:
+		case <-abort.Done():
+			return
+		}
+		logrus.Warnln("Interrupted again, aborting the current step.")
+		signal.Stop(sigs)
+		cancelAbort()
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+		cancelAbort()
+	}
+}
+
+// AbortContext returns the context of ctx that is only cancelled when the
+// running step must be aborted, see SignalContext. Without one, ctx itself is
+// returned.
+func AbortContext(ctx context.Context) context.Context {
+	if abort, ok := ctx.Value(abortKey{}).(context.Context); ok {
+		return abort
+	}
+	return ctx
+}
+
+// AbortableContext returns a copy of ctx that is cancelled together with its
+// abort context, see AbortContext, when abort is called, stopping the running
+// step at once.
+func AbortableContext(ctx context.Context) (context.Context, func()) {
+	abort, cancelAbort := context.WithCancel(AbortContext(ctx))
+	run, cancel := context.WithCancel(context.WithValue(ctx, abortKey{}, abort))
+	go func() {
+		select {
+		case <-abort.Done():
+			cancel()
+		case <-run.Done():
+		}
+	}()
+	return run, func() {
+		cancelAbort()
+		cancel()
+	}
+}